@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Default commands if none are provided in config. Each word is expanded as
+// a template on its own, so file names with spaces are passed correctly.
+const (
+	defaultSynthCommand   = "fluidsynth -ni -F {{.output}} -r 44100 {{.soundfont}} {{.midi}}"
+	defaultEncoderCommand = "ffmpeg -y -loglevel error -i {{.input}} {{.output}}"
+)
+
+// renderAudio renders {midiFile} to an audio file in {format} next to it
+// and returns the path of the audio file. The configured synthesizer
+// creates a WAV file, which is then converted by the configured encoder if
+// another format is wanted.
+func (m *maker) renderAudio(midiFile, format string) (string, error) {
+	config := GetConfig().Audio
+	if config.Soundfont == "" {
+		return "", errors.New("no soundfont set for audio rendering")
+	}
+	synth := config.Synth
+	if synth == "" {
+		synth = defaultSynthCommand
+	}
+	encoder := config.Encoder
+	if encoder == "" {
+		encoder = defaultEncoderCommand
+	}
+
+	base := strings.TrimSuffix(midiFile, filepath.Ext(midiFile))
+	output := base + "." + format
+	wavFile := output
+	if format != "wav" {
+		wavFile = filepath.Join(m.workDir, filepath.Base(base)+".wav")
+	}
+
+	err := m.runTool(synth, map[string]any{
+		"midi":      midiFile,
+		"soundfont": config.Soundfont,
+		"output":    wavFile,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to render audio: %w", err)
+	}
+	if format == "wav" {
+		return output, nil
+	}
+
+	err = m.runTool(encoder, map[string]any{
+		"input":  wavFile,
+		"output": output,
+		"format": format,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audio as %s: %w", format, err)
+	}
+
+	return output, nil
+}
+
+// runTool runs the command line {cmdTemplate} with each word expanded as a
+// template with {data}. Output from the command is passed on to the
+// maker's output.
+func (m *maker) runTool(cmdTemplate string, data map[string]any) error {
+	args := []string{}
+	for _, word := range strings.Fields(cmdTemplate) {
+		arg, err := executeTemplate(word, data)
+		if err != nil {
+			return err
+		}
+		args = append(args, arg)
+	}
+	if len(args) == 0 {
+		return errors.New("empty command")
+	}
+
+	c := exec.CommandContext(m.ctx, args[0], args[1:]...)
+	c.Stdout = m.out
+	c.Stderr = m.out
+	return c.Run()
+}
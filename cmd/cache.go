@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+const buildCacheFile = ".domusic-cache.json"
+
+// buildCache remembers a content hash and the output files for every tune
+// that was built successfully, keyed on the tune's path relative to the
+// music root. It is stored in the output directory and is safe for
+// concurrent use.
+type buildCache struct {
+	mu      sync.Mutex
+	path    string
+	Entries map[string]cacheEntry `json:"entries"`
+}
+
+// cacheEntry is what the cache knows about a single tune. Output paths are
+// relative to the music root. Files has the outputs of all builds of the
+// tune that may still be there, also those not tracked by the hash, so
+// pages that are no longer made can be removed.
+type cacheEntry struct {
+	Hash    string   `json:"hash"`
+	Outputs []string `json:"outputs"`
+	Files   []string `json:"files,omitempty"`
+}
+
+// loadBuildCache reads the build cache from the output directory. A missing
+// or unreadable cache file results in an empty cache.
+func loadBuildCache() *buildCache {
+	c := &buildCache{
+		path:    pathFromRoot(outputDir, buildCacheFile),
+		Entries: map[string]cacheEntry{},
+	}
+	if data, err := os.ReadFile(c.path); err == nil {
+		if err := json.Unmarshal(data, c); err != nil {
+			printWarning("ignoring broken build cache %s: %w", c.path, err)
+		}
+	}
+	if c.Entries == nil {
+		c.Entries = map[string]cacheEntry{}
+	}
+
+	return c
+}
+
+// upToDate reports whether {src} was last built from content with the hash
+// {hash} and all its output files are still there. If so, it also returns
+// the full paths of the output files.
+func (c *buildCache) upToDate(src, hash string) ([]string, bool) {
+	c.mu.Lock()
+	entry, ok := c.Entries[makeRel(src)]
+	c.mu.Unlock()
+	if !ok || entry.Hash != hash || len(entry.Outputs) == 0 {
+		return nil, false
+	}
+
+	outputs := []string{}
+	for _, o := range entry.Outputs {
+		p := pathFromRoot(o)
+		if _, err := os.Stat(p); err != nil {
+			return nil, false
+		}
+		outputs = append(outputs, p)
+	}
+
+	return outputs, true
+}
+
+// set stores {hash} and the full paths of the output files for {src}.
+func (c *buildCache) set(src, hash string, outputs []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.Entries[makeRel(src)]
+	entry.Hash, entry.Outputs = hash, []string{}
+	for _, o := range outputs {
+		entry.Outputs = append(entry.Outputs, makeRel(o))
+	}
+	c.Entries[makeRel(src)] = entry
+}
+
+// files returns the full paths of the output files from earlier builds of
+// {src}. A nil cache knows no files.
+func (c *buildCache) files(src string) []string {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	files := []string{}
+	for _, f := range c.Entries[makeRel(src)].Files {
+		files = append(files, pathFromRoot(f))
+	}
+
+	return files
+}
+
+// addFiles records the full paths of the output files {outputs} for {src}.
+// Files that are gone are forgotten. A nil cache records nothing.
+func (c *buildCache) addFiles(src string, outputs []string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.Entries[makeRel(src)]
+	files := []string{}
+	for _, f := range entry.Files {
+		if _, err := os.Stat(pathFromRoot(f)); err == nil {
+			files = append(files, f)
+		}
+	}
+	for _, o := range outputs {
+		files = append(files, makeRel(o))
+	}
+	slices.Sort(files)
+	entry.Files = slices.Compact(files)
+	c.Entries[makeRel(src)] = entry
+}
+
+// save writes the cache back to the output directory.
+func (c *buildCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(c.path, data, 0644)
+}
@@ -17,7 +17,8 @@ const collectionHeaderTemplate = `%% Generated by domusic
 \version "{{.version}}"
 
 \header {
-  title = "{{.title}}"
+  title = "{{.title}}"{{if .subtitle}}
+  subtitle = "{{.subtitle}}"{{end}}
 }
 
 \paper {
@@ -30,6 +31,9 @@ const collectionHeaderTemplate = `%% Generated by domusic
   }
 }
 
+{{range .cover}}\markup \fill-line { "{{.}}" }
+{{end}}{{if .cover}}\pageBreak
+{{end}}
 \markuplist \table-of-contents
 
 \pageBreak
@@ -40,7 +44,67 @@ const collectionHeaderTemplate = `%% Generated by domusic
 var collectionCmd = &cli.Command{
 	Name:  "collection",
 	Usage: "Generate a collection document given a number of files",
-	Flags: []cli.Flag{
+	Flags: collectionFlags(),
+	Commands: []*cli.Command{
+		collectionBuildCmd,
+	},
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		files, err := expandGlobs(cmd.Args().Slice())
+		if err != nil {
+			return err
+		}
+
+		sort.Slice(files, func(i, j int) bool {
+			return pathForSort(files[i]) < pathForSort(files[j])
+		})
+
+		section := collectionSection{}
+		for _, f := range files {
+			src := getSourcePath(f)
+			section.tunes = append(section.tunes, collectionTune{path: src, include: makeRel(src)})
+		}
+		doc, err := generateCollection(cmd, collectionInfo{title: cmd.String("title")}, []collectionSection{section})
+		if err != nil {
+			return printAndReturnError("%w", err)
+		}
+
+		if output := cmd.String("output"); output != "" {
+			return compileCollection(ctx, cmd, output, doc)
+		}
+		fmt.Println(doc)
+		return nil
+	},
+}
+
+// compileCollection writes the collection document {doc} to {name}.ly in
+// the music root, where the paths of its includes are relative to, and
+// builds the PDF and preview with Lilypond like make does. The document is
+// removed afterwards, and an existing file with the same name is never
+// touched.
+func compileCollection(ctx context.Context, cmd *cli.Command, name, doc string) error {
+	src := ensureSuffix(pathFromRoot(name), ".ly")
+	if _, err := os.Stat(src); err == nil {
+		return printAndReturnError("%s already exists, use another output name", makeRel(src))
+	}
+	if err := os.WriteFile(src, []byte(doc), 0644); err != nil {
+		return printAndReturnError("failed to write %s: %w", src, err)
+	}
+	defer os.Remove(src)
+
+	runner := newLilypondRunner()
+	m := &maker{ctx: ctx, cmd: cmd, out: os.Stdout, runner: runner, lilyVersion: installedVersion(ctx, runner), document: true}
+	fmt.Fprintln(m.out, "Processing collection", makeRel(src))
+	if err := m.build(src, []string{"pdf"}, cmd.Int("resolution")); err != nil {
+		return printAndReturnError("failed to build collection %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// collectionFlags returns the flags of the collection command, which are
+// also used by its subcommands.
+func collectionFlags() []cli.Flag {
+	return []cli.Flag{
 		&cli.StringFlag{
 			Name:    "title",
 			Aliases: []string{"t"},
@@ -59,6 +123,12 @@ var collectionCmd = &cli.Command{
 			Value:   "a4",
 			Usage:   "paper size",
 		},
+		&cli.IntFlag{
+			Name:    "resolution",
+			Aliases: []string{"r"},
+			Value:   144,
+			Usage:   "resolution for the preview image made with --output",
+		},
 		&cli.BoolFlag{
 			Name:  "point-and-click",
 			Usage: "turn on point-and-click",
@@ -71,75 +141,144 @@ var collectionCmd = &cli.Command{
 			Name:  "font-include",
 			Usage: "include font configuration file",
 		},
-	},
-	Action: func(ctx context.Context, cmd *cli.Command) error {
-		args := cmd.Args().Slice()
-		title := cmd.String("title")
-		data := map[string]any{
-			"version":       lowestLilyVersion,
-			"title":         title,
-			"pointAndClick": cmd.Bool("point-and-click"),
-			"staffSize":     cmd.Int("staff-size"),
-			"paperSize":     cmd.String("paper-size"),
-			"viewSpacing":   cmd.Bool("view-spacing"),
-			"fontInclude":   GetConfig().FontInclude,
-		}
-		common := GetConfig().Template.Common
-		if common != "" {
-			commonExpanded, err := executeTemplate(common, data)
-			if err != nil {
-				return printAndReturnError("failed to execute common template: %w", err)
-			}
-			common = commonExpanded
-		}
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "compile the collection to _output/{name}.pdf instead of printing it",
+		},
+	}
+}
+
+// collectionInfo is the text on the first pages of a collection. The lines
+// of {cover} are printed on a page of their own before the contents.
+type collectionInfo struct {
+	title, subtitle, cover string
+}
+
+// collectionSection is a group of tunes in a collection, listed under
+// {heading} unless it is empty.
+type collectionSection struct {
+	heading string
+	tunes   []collectionTune
+}
 
-		headerTemplate := GetConfig().Template.Collection
-		if headerTemplate == "" {
-			headerTemplate = collectionHeaderTemplate
+// collectionTune is a tune in a collection. {path} is the full path of the
+// source and {include} how the generated document includes it. Tunes with a
+// transposition or staff size are copied into the document instead, with
+// their own includes resolved.
+type collectionTune struct {
+	path      string
+	include   string
+	transpose string
+	staffSize int
+	pageBreak bool
+}
+
+// generateCollection returns the Lilypond document for a collection with
+// the tunes in {sections}, using the collection template and the flags in
+// {cmd}. The titles of the tunes are taken from the library index.
+func generateCollection(cmd *cli.Command, info collectionInfo, sections []collectionSection) (string, error) {
+	cover := []string{}
+	if info.cover != "" {
+		for _, line := range strings.Split(strings.TrimRight(info.cover, "\n"), "\n") {
+			cover = append(cover, escapeLyString(line))
 		}
-		data["common"] = common
-		template, err := executeTemplate(headerTemplate, data)
+	}
+	data := map[string]any{
+		"version":       lowestLilyVersion,
+		"title":         escapeLyString(info.title),
+		"subtitle":      escapeLyString(info.subtitle),
+		"cover":         cover,
+		"pointAndClick": cmd.Bool("point-and-click"),
+		"staffSize":     cmd.Int("staff-size"),
+		"paperSize":     cmd.String("paper-size"),
+		"viewSpacing":   cmd.Bool("view-spacing"),
+		"fontInclude":   GetConfig().FontInclude,
+	}
+	common := GetConfig().Template.Common
+	if common != "" {
+		commonExpanded, err := executeTemplate(common, data)
 		if err != nil {
-			return printAndReturnError("failed to execute collection header template: %w", err)
-		}
-
-		files := []string{}
-		for _, arg := range args {
-			if strings.Contains(arg, "*") {
-				f, err := filepath.Glob(pathFromRoot(arg))
-				if err != nil {
-					return printAndReturnError("failed to expand glob pattern %s: %w", arg, err)
-				}
-				if len(f) == 0 {
-					printWarning("no files matched pattern %s", arg)
-				}
-				files = append(files, f...)
-			} else {
-				files = append(files, arg)
-			}
+			return "", fmt.Errorf("failed to execute common template: %w", err)
 		}
+		common = commonExpanded
+	}
 
-		sort.Slice(files, func(i, j int) bool {
-			return pathForSort(files[i]) < pathForSort(files[j])
-		})
+	headerTemplate := GetConfig().Template.Collection
+	if headerTemplate == "" {
+		headerTemplate = collectionHeaderTemplate
+	}
+	data["common"] = common
+	template, err := executeTemplate(headerTemplate, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute collection header template: %w", err)
+	}
 
-		for _, f := range files {
-			fd, err := os.ReadFile(getSourcePath(f))
+	var sb strings.Builder
+	sb.WriteString(template)
+	idx := loadLibraryIndex()
+	for _, s := range sections {
+		if s.heading != "" {
+			heading := escapeLyString(s.heading)
+			fmt.Fprintf(&sb, "\\tocItem \\markup \\bold \"%s\"\n", heading)
+			fmt.Fprintf(&sb, "\\markup \\fill-line { \\huge \\bold \"%s\" }\n\n", heading)
+		}
+		for _, t := range s.tunes {
+			entry, err := idx.get(t.path)
 			if err != nil {
-				return printAndReturnError("failed to read file %s: %w", f, err)
+				return "", fmt.Errorf("failed to read file %s: %w", t.include, err)
+			}
+			title := entry.Header["title"]
+			if title == "" {
+				return "", fmt.Errorf("no title found in file: %s", t.include)
+			}
+			if t.pageBreak {
+				sb.WriteString("\\pageBreak\n")
 			}
-			matches := titleRx.FindSubmatch(fd)
-			if len(matches) < 2 {
-				return printAndReturnError("no title found in file: %s", f)
+			fmt.Fprintf(&sb, "\\tocItem \\markup \"%s\"\n", escapeLyString(title))
+			if t.transpose == "" && t.staffSize == 0 {
+				fmt.Fprintf(&sb, "\\include \"%s\"\n\n", t.include)
+				continue
 			}
-			title := matches[1]
-			template += fmt.Sprintf("\\tocItem \\markup \"%s\"\n", title)
-			template += fmt.Sprintf("\\include \"%s\"\n\n", f)
+			source, err := inlineTune(t)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&sb, "%%%% From %s\n%s\n\n", t.include, source)
 		}
+	}
+	if err := idx.save(); err != nil {
+		printWarning("failed to save library index: %w", err)
+	}
 
-		fmt.Println(template)
-		return nil
-	},
+	return sb.String(), nil
+}
+
+// inlineTune returns the source of {t} with its transposition and staff
+// size applied, and with its includes changed to full paths.
+func inlineTune(t collectionTune) ([]byte, error) {
+	source, err := os.ReadFile(t.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file %s: %w", t.include, err)
+	}
+	if t.transpose != "" {
+		from, to, err := parseTranspose(t.transpose)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", t.include, err)
+		}
+		var ok bool
+		if source, ok = addTranspose(source, from, to); !ok {
+			return nil, fmt.Errorf("can't transpose %s, it has no music", t.include)
+		}
+	}
+	if t.staffSize > 0 {
+		var ok bool
+		if source, ok = addStaffSize(source, t.staffSize); !ok {
+			return nil, fmt.Errorf("can't set the staff size of %s, it has no music", t.include)
+		}
+	}
+
+	return resolveIncludePaths(source, filepath.Dir(t.path)), nil
 }
 
 func pathForSort(path string) string {
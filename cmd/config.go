@@ -12,12 +12,44 @@ import (
 
 // Config holds all configuration values for domusic
 type Config struct {
-	Root        string         `yaml:"root" env:"DOMUSIC_ROOT"`
-	LyEditor    string         `yaml:"ly-editor" env:"DOMUSIC_LY_EDITOR"`
-	LyViewer    string         `yaml:"ly-viewer" env:"DOMUSIC_LY_VIEWER"`
-	FontInclude string         `yaml:"font-include" env:"DOMUSIC_FONT_INCLUDE"`
-	Sync        SyncConfig     `yaml:"sync"`
-	Template    TemplateConfig `yaml:"template"`
+	Root         string                `yaml:"root" env:"DOMUSIC_ROOT"`
+	LyEditor     string                `yaml:"ly-editor" env:"DOMUSIC_LY_EDITOR"`
+	LyViewer     string                `yaml:"ly-viewer" env:"DOMUSIC_LY_VIEWER"`
+	FontInclude  string                `yaml:"font-include" env:"DOMUSIC_FONT_INCLUDE"`
+	IncludePaths []string              `yaml:"include-paths" env:"DOMUSIC_INCLUDE_PATHS"`
+	OutputTypes  []string              `yaml:"output-types" env:"DOMUSIC_OUTPUT_TYPES"`
+	Lilypond     LilypondConfig        `yaml:"lilypond"`
+	Audio        AudioConfig           `yaml:"audio"`
+	Post         map[string]PostPreset `yaml:"post"`
+	Sync         SyncConfig            `yaml:"sync"`
+	Template     TemplateConfig        `yaml:"template"`
+}
+
+// LilypondConfig holds configuration for running Lilypond
+type LilypondConfig struct {
+	Binary    string   `yaml:"binary" env:"DOMUSIC_LILYPOND_BINARY"`
+	ConvertLy string   `yaml:"convert-ly" env:"DOMUSIC_LILYPOND_CONVERT_LY"`
+	Args      []string `yaml:"args" env:"DOMUSIC_LILYPOND_ARGS"`
+	Timeout   string   `yaml:"timeout" env:"DOMUSIC_LILYPOND_TIMEOUT"`
+}
+
+// AudioConfig holds configuration for rendering MIDI files to audio
+type AudioConfig struct {
+	Synth     string `yaml:"synth" env:"DOMUSIC_AUDIO_SYNTH"`
+	Soundfont string `yaml:"soundfont" env:"DOMUSIC_AUDIO_SOUNDFONT"`
+	Encoder   string `yaml:"encoder" env:"DOMUSIC_AUDIO_ENCODER"`
+}
+
+// PostPreset holds the settings for one kind of image made with --post
+type PostPreset struct {
+	Width      int    `yaml:"width"`
+	Height     int    `yaml:"height"`
+	Aspect     string `yaml:"aspect"`
+	Padding    int    `yaml:"padding"`
+	Background string `yaml:"background"`
+	Resolution int    `yaml:"resolution"`
+	Footer     string `yaml:"footer"`
+	URL        string `yaml:"url"`
 }
 
 // SyncConfig holds configuration for the sync command
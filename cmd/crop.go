@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"os/exec"
+)
+
+// trimImage returns {img} cut down to the smallest rectangle holding all
+// pixels that aren't background, surrounded by a white border that is
+// {border} pixels wide. An image with nothing but background is returned
+// unchanged.
+func trimImage(img image.Image, border int) image.Image {
+	b := img.Bounds()
+	box := image.Rectangle{}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if !isBackground(img.At(x, y)) {
+				box = box.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	if box.Empty() {
+		return img
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, box.Dx()+2*border, box.Dy()+2*border))
+	draw.Draw(out, out.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(out, box.Sub(box.Min).Add(image.Pt(border, border)), img, box.Min, draw.Over)
+
+	return out
+}
+
+// isBackground reports whether {c} is white enough, or transparent enough,
+// to be trimmed away. A little slack allows for anti-aliasing noise.
+func isBackground(c color.Color) bool {
+	const limit = 0xf000
+	r, g, b, a := c.RGBA()
+	if a < 0x1000 {
+		return true
+	}
+	// Compare the colour as if it was drawn on white
+	r, g, b = r+0xffff-a, g+0xffff-a, b+0xffff-a
+	return r >= limit && g >= limit && b >= limit
+}
+
+// stitchImages returns {imgs} stacked on top of each other on a white
+// background, each of them centred horizontally.
+func stitchImages(imgs []image.Image) image.Image {
+	width, height := 0, 0
+	for _, img := range imgs {
+		width = max(width, img.Bounds().Dx())
+		height += img.Bounds().Dy()
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(out, out.Bounds(), image.White, image.Point{}, draw.Src)
+	y := 0
+	for _, img := range imgs {
+		b := img.Bounds()
+		at := image.Rect(0, 0, b.Dx(), b.Dy()).Add(image.Pt((width-b.Dx())/2, y))
+		draw.Draw(out, at, img, b.Min, draw.Over)
+		y += b.Dy()
+	}
+
+	return out
+}
+
+// cropPNG trims the PNG file at {path} in place, leaving a border of
+// {border} pixels around the content.
+func cropPNG(path string, border int) error {
+	img, err := readPNG(path)
+	if err != nil {
+		return err
+	}
+
+	return writePNG(path, trimImage(img, border))
+}
+
+// stitchPNGs stitches the PNG files {paths} together into a single image
+// in {dst}.
+func stitchPNGs(paths []string, dst string) error {
+	imgs := []image.Image{}
+	for _, p := range paths {
+		img, err := readPNG(p)
+		if err != nil {
+			return err
+		}
+		imgs = append(imgs, img)
+	}
+
+	return writePNG(dst, stitchImages(imgs))
+}
+
+// readPNG decodes the PNG file at {path}.
+func readPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return img, nil
+}
+
+// writePNG encodes {img} as a PNG file at {path}.
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	return f.Close()
+}
+
+// mogrifyCrop trims the PNG file at {path} in place with ImageMagick. It is
+// only used if the image can't be trimmed by cropPNG.
+func mogrifyCrop(path string, border int) error {
+	if _, err := exec.LookPath("mogrify"); err != nil {
+		return err
+	}
+	c := exec.Command("mogrify", "-trim", "-bordercolor", "white", "-border", fmt.Sprint(border), path)
+
+	return c.Run()
+}
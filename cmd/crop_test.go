@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testImage returns a white image of size {w}x{h} with a black rectangle
+// at {content}.
+func testImage(w, h int, content image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(img, content, image.Black, image.Point{}, draw.Src)
+	return img
+}
+
+func Test_trimImage(t *testing.T) {
+	tests := []struct {
+		name      string
+		img       image.Image
+		border    int
+		wantSize  image.Point
+		wantBlack image.Point
+	}{
+		{"with_border", testImage(100, 50, image.Rect(20, 10, 30, 20)), 5, image.Pt(20, 20), image.Pt(5, 5)},
+		{"without_border", testImage(100, 50, image.Rect(20, 10, 30, 20)), 0, image.Pt(10, 10), image.Pt(0, 0)},
+		{"content_at_edge", testImage(100, 50, image.Rect(0, 0, 100, 1)), 2, image.Pt(104, 5), image.Pt(2, 2)},
+		{"blank", testImage(100, 50, image.Rectangle{}), 5, image.Pt(100, 50), image.Pt(-1, -1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := trimImage(tt.img, tt.border)
+			if size := got.Bounds().Size(); size != tt.wantSize {
+				t.Fatalf("trimImage() size = %v, want %v", size, tt.wantSize)
+			}
+			if tt.wantBlack.X < 0 {
+				return
+			}
+			if c := color.GrayModel.Convert(got.At(tt.wantBlack.X, tt.wantBlack.Y)).(color.Gray); c.Y != 0 {
+				t.Errorf("pixel at %v = %v, want black", tt.wantBlack, c)
+			}
+			if tt.border > 0 && !isBackground(got.At(tt.wantBlack.X-1, tt.wantBlack.Y-1)) {
+				t.Errorf("pixel in the border should be white")
+			}
+		})
+	}
+}
+
+func Test_cropPNG(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tune.png")
+	f, _ := os.Create(path)
+	png.Encode(f, testImage(200, 100, image.Rect(50, 40, 60, 45)))
+	f.Close()
+
+	if err := cropPNG(path, 12); err != nil {
+		t.Fatalf("cropPNG() failed: %v", err)
+	}
+	f, _ = os.Open(path)
+	defer f.Close()
+	cfg, err := png.DecodeConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 34 || cfg.Height != 29 {
+		t.Errorf("cropped size = %dx%d, want 34x29", cfg.Width, cfg.Height)
+	}
+}
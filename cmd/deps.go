@@ -0,0 +1,291 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+
+	"github.com/urfave/cli/v3"
+)
+
+var depsCmd = &cli.Command{
+	Name:      "deps",
+	Usage:     "List all files included by a Lilypond music file <file>",
+	ArgsUsage: "<file>",
+	Flags:     dependencyFlags(),
+	Arguments: []cli.Argument{
+		&cli.StringArg{
+			Name: "file",
+		},
+	},
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		file := cmd.StringArg("file")
+		if file == "" {
+			return printAndReturnError("deps needs a file name")
+		}
+		setFontInclude(cmd)
+
+		m := &maker{cmd: cmd, out: os.Stdout}
+		deps, err := m.dependencies(getSourcePath(file))
+		if err != nil {
+			return printAndReturnError("failed to find dependencies: %w", err)
+		}
+		for _, dep := range deps {
+			fmt.Println(makeRel(dep))
+		}
+		return nil
+	},
+}
+
+var rdepsCmd = &cli.Command{
+	Name:      "rdeps",
+	Usage:     "List all music files that include <include>, directly or indirectly",
+	ArgsUsage: "<include>",
+	Flags:     dependencyFlags(),
+	Arguments: []cli.Argument{
+		&cli.StringArg{
+			Name: "include",
+		},
+	},
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		include := cmd.StringArg("include")
+		if include == "" {
+			return printAndReturnError("rdeps needs an include file name")
+		}
+		setFontInclude(cmd)
+
+		target := resolveInclude(include, ".")
+		if target == "" {
+			return printAndReturnError("include file does not exist: %s", include)
+		}
+
+		m := &maker{cmd: cmd, out: os.Stdout}
+		dependents, err := m.dependents(target)
+		if err != nil {
+			return printAndReturnError("failed to find dependent files: %w", err)
+		}
+		for _, tune := range dependents {
+			fmt.Println(makeRel(tune))
+		}
+		return nil
+	},
+}
+
+// dependencyFlags returns the flags of the make command that change which
+// files get included in the generated document.
+func dependencyFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "format",
+			Aliases: []string{"f"},
+			Value:   "default",
+			Usage:   "use header format file header_{format}",
+		},
+		&cli.StringFlag{
+			Name:  "font-include",
+			Usage: "include font configuration file",
+		},
+	}
+}
+
+// setFontInclude overrides the font include file from the configuration if
+// it is given on the command line.
+func setFontInclude(cmd *cli.Command) {
+	if cmd.IsSet("font-include") {
+		GetConfig().FontInclude = cmd.String("font-include")
+	}
+}
+
+// dependencies returns the full paths of all files that the generated
+// document for {src} includes, directly or indirectly. This covers the
+// files included by the templates as well as by the tune itself.
+func (m *maker) dependencies(src string) ([]string, error) {
+	doc, _, err := m.renderTemplate(src, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return findDependencies(src, []byte(doc)), nil
+}
+
+// dependents returns the full paths of all tunes in the music hierarchy
+// whose generated document includes {include}. Files that are themselves
+// included by other files are not tunes and are left out. The includes of
+// each tune are kept in the library index between runs.
+func (m *maker) dependents(include string) ([]string, error) {
+	idx := loadLibraryIndex()
+	entries, err := idx.refresh()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := idx.save(); err != nil {
+			printWarning("failed to save library index: %w", err)
+		}
+	}()
+
+	included := map[string]bool{}
+	candidates := []string{}
+	for _, e := range entries {
+		f := pathFromRoot(e.Path)
+		deps, err := idx.dependencies(m, f)
+		if err != nil {
+			printWarning("skipping %s: %w", makeRel(f), err)
+			continue
+		}
+		for _, dep := range deps {
+			included[dep] = true
+		}
+		if slices.Contains(deps, include) {
+			candidates = append(candidates, f)
+		}
+	}
+
+	dependents := []string{}
+	for _, f := range candidates {
+		if abs, err := filepath.Abs(f); err == nil && !included[abs] {
+			dependents = append(dependents, f)
+		}
+	}
+
+	return dependents, nil
+}
+
+var includeRx = regexp.MustCompile(`\\include\s+"([^"]+)"`)
+
+// stripComments returns {src} with all Lilypond comments replaced by spaces.
+// Both line comments (%) and block comments (%{ ... %}) are handled, and
+// percent signs inside strings are left alone. Newlines are kept so line
+// numbers stay the same.
+func stripComments(src []byte) []byte {
+	out := make([]byte, len(src))
+	copy(out, src)
+
+	inString := false
+	for i := 0; i < len(out); i++ {
+		c := out[i]
+		switch {
+		case inString:
+			if c == '\\' && i+1 < len(out) {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '%' && i+1 < len(out) && out[i+1] == '{':
+			for ; i < len(out); i++ {
+				if out[i] == '%' && i+1 < len(out) && out[i+1] == '}' {
+					out[i], out[i+1] = ' ', ' '
+					i++
+					break
+				}
+				if out[i] != '\n' {
+					out[i] = ' '
+				}
+			}
+		case c == '%':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		}
+	}
+
+	return out
+}
+
+// parseIncludes returns the file names of all \include statements in {src}
+// in the order they appear. Commented out includes are ignored.
+func parseIncludes(src []byte) []string {
+	includes := []string{}
+	for _, m := range includeRx.FindAllSubmatch(stripComments(src), -1) {
+		includes = append(includes, string(m[1]))
+	}
+
+	return includes
+}
+
+// resolveInclude returns the full path of the file an \include of {name}
+// refers to, looking first in {dir}, then in the music root and last in the
+// include paths from the configuration. It returns an empty string if no
+// such file exists, which is the case for files that come with Lilypond
+// itself.
+func resolveInclude(name, dir string) string {
+	candidates := []string{name}
+	if !filepath.IsAbs(name) {
+		candidates = []string{filepath.Join(dir, name), pathFromRoot(name)}
+		for _, p := range getIncludePaths() {
+			candidates = append(candidates, filepath.Join(p, name))
+		}
+	}
+	for _, c := range candidates {
+		if info, err := os.Stat(c); err == nil && info.Mode().IsRegular() {
+			if abs, err := filepath.Abs(c); err == nil {
+				return abs
+			}
+			return filepath.Clean(c)
+		}
+	}
+
+	return ""
+}
+
+// resolveIncludePaths returns {src} with the file name of every \include
+// that refers to a file in {dir}, the music root or the include paths
+// replaced by its full path, so the source can be used from anywhere.
+func resolveIncludePaths(src []byte, dir string) []byte {
+	return includeRx.ReplaceAllFunc(src, func(m []byte) []byte {
+		name := string(includeRx.FindSubmatch(m)[1])
+		p := resolveInclude(name, dir)
+		if p == "" {
+			return m
+		}
+		return []byte(fmt.Sprintf(`\include "%s"`, escapeLyString(p)))
+	})
+}
+
+// findDependencies returns the sorted full paths of all files included by
+// {src}, directly or through other included files. The contents of {src}
+// are given as {data} so generated documents that are not yet on disk can
+// be scanned. Includes that can't be resolved are ignored.
+func findDependencies(src string, data []byte) []string {
+	deps, _ := scanDependencies(src, data)
+	return deps
+}
+
+// scanDependencies works like findDependencies, but also returns the
+// includes that can't be resolved, so they can be looked for again later.
+func scanDependencies(src string, data []byte) ([]string, []missingInclude) {
+	seen := map[string]bool{}
+	missing := []missingInclude{}
+	var walk func(dir string, data []byte)
+	walk = func(dir string, data []byte) {
+		for _, name := range parseIncludes(data) {
+			p := resolveInclude(name, dir)
+			if p == "" {
+				missing = append(missing, missingInclude{Name: name, Dir: makeRel(dir)})
+				continue
+			}
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			if d, err := os.ReadFile(p); err == nil {
+				walk(filepath.Dir(p), d)
+			}
+		}
+	}
+	walk(filepath.Dir(src), data)
+
+	deps := make([]string, 0, len(seen))
+	for p := range seen {
+		deps = append(deps, p)
+	}
+	sort.Strings(deps)
+
+	return deps, missing
+}
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/urfave/cli/v3"
+)
+
+func Test_parseIncludes(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{"no_includes", `\score { c d e }`, []string{}},
+		{"single_include", `\include "bagpipe.ly"`, []string{"bagpipe.ly"}},
+		{"multiple_includes", "\\include \"a.ily\"\n\\include \"sub/b.ly\"", []string{"a.ily", "sub/b.ly"}},
+		{"line_comment", "% \\include \"a.ily\"\n\\include \"b.ily\"", []string{"b.ily"}},
+		{"block_comment", "%{\n\\include \"a.ily\"\n%}\n\\include \"b.ily\"", []string{"b.ily"}},
+		{"percent_in_string", "title = \"100% jig\" \\include \"b.ily\"", []string{"b.ily"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseIncludes([]byte(tt.src)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseIncludes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_findDependencies(t *testing.T) {
+	root := t.TempDir()
+	resetConfigForTest()
+	t.Cleanup(resetConfigForTest)
+	GetConfig().Root = root
+
+	files := map[string]string{
+		"header_default.ly": `\include "defs/common.ily"`,
+		"defs/common.ily":   `\include "bagpipe.ly"`,
+		"jigs/local.ily":    `\include "../header_default.ly"`,
+		"jigs/tune.ly":      "\\include \"header_default.ly\"\n\\include \"local.ily\"",
+	}
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	src := filepath.Join(root, "jigs/tune.ly")
+	data, _ := os.ReadFile(src)
+	want := []string{
+		filepath.Join(root, "defs/common.ily"),
+		filepath.Join(root, "header_default.ly"),
+		filepath.Join(root, "jigs/local.ily"),
+	}
+	if got := findDependencies(src, data); !reflect.DeepEqual(got, want) {
+		t.Errorf("findDependencies() = %v, want %v", got, want)
+	}
+}
+
+// captureStdout returns what {fn} writes to standard output, and the error
+// it returns.
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = old }()
+
+	done := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- data
+	}()
+	err = fn()
+	w.Close()
+
+	return string(<-done), err
+}
+
+// dependencyTestFiles is a small music hierarchy where one tune includes a
+// shared file directly and others include it through a local file or a
+// shared .ly file.
+var dependencyTestFiles = map[string]string{
+	"defs/common.ily":   "",
+	"fonts/myfont.ily":  "",
+	"header_default.ly": `\include "defs/common.ily"`,
+	"jigs/slip.ly":      "\\include \"header_default.ly\"\n\\header { title = \"Slip\" }\n{ c'4 }",
+	"jigs/tune.ly":      "\\include \"defs/common.ily\"\n\\header { title = \"Jig\" }\n{ c'4 }",
+	"reels/local.ily":   `\include "defs/common.ily"`,
+	"reels/reel.ly":     "\\include \"local.ily\"\n\\header { title = \"Reel\" }\n{ c'4 }",
+	"reels/other.ly":    "\\header { title = \"Other\" }\n{ c'4 }",
+}
+
+func Test_depsCmd(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{"direct", []string{"jigs/tune"}, "defs/common.ily\n", false},
+		{"indirect", []string{"reels/reel"}, "defs/common.ily\nreels/local.ily\n", false},
+		{"ly_include", []string{"jigs/slip"}, "defs/common.ily\nheader_default.ly\n", false},
+		{"none", []string{"reels/other"}, "", false},
+		{"font_include", []string{"--font-include", "fonts/myfont", "jigs/tune"}, "defs/common.ily\nfonts/myfont.ily\n", false},
+		{"no_file", []string{}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupMakeTest(t, dependencyTestFiles)
+			cmd := &cli.Command{Name: "deps", Flags: dependencyFlags(), Arguments: depsCmd.Arguments, Action: depsCmd.Action}
+
+			got, err := captureStdout(t, func() error {
+				return cmd.Run(context.Background(), append([]string{"deps"}, tt.args...))
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("deps error = %v, want error %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("deps printed %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_rdepsCmd(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{"direct_and_indirect", []string{"defs/common.ily"}, "jigs/slip.ly\njigs/tune.ly\nreels/reel.ly\n", false},
+		{"local", []string{"reels/local.ily"}, "reels/reel.ly\n", false},
+		{"ly_include", []string{"header_default.ly"}, "jigs/slip.ly\n", false},
+		{"font_include", []string{"--font-include", "fonts/myfont", "fonts/myfont.ily"}, "jigs/slip.ly\njigs/tune.ly\nreels/other.ly\nreels/reel.ly\n", false},
+		{"unused", []string{"fonts/myfont.ily"}, "", false},
+		{"missing", []string{"nothing.ily"}, "", true},
+		{"no_include", []string{}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupMakeTest(t, dependencyTestFiles)
+			cmd := &cli.Command{Name: "rdeps", Flags: dependencyFlags(), Arguments: rdepsCmd.Arguments, Action: rdepsCmd.Action}
+
+			got, err := captureStdout(t, func() error {
+				return cmd.Run(context.Background(), append([]string{"rdeps"}, tt.args...))
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("rdeps error = %v, want error %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("rdeps printed %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
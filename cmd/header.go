@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"strings"
+)
+
+// lyToken is a token in a Lilypond source. {kind} is one of '{', '}', '=',
+// 's' for a string, '\\' for a command like \markup, '#' for a Scheme
+// expression and 'w' for any other word. The text of a string is its
+// unescaped contents. {start} and {end} are its position in the source.
+type lyToken struct {
+	kind       byte
+	text       string
+	start, end int
+}
+
+// tokenize splits {src} into tokens. Comments are skipped, and Scheme
+// expressions are kept whole, except for Scheme strings, which are returned
+// as ordinary strings.
+func tokenize(src []byte) []lyToken {
+	clean := stripComments(src)
+	tokens := []lyToken{}
+	for i := 0; i < len(clean); {
+		c := clean[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '{' || c == '}' || c == '=':
+			tokens = append(tokens, lyToken{c, string(c), i, i + 1})
+			i++
+		case c == '"':
+			text, end := readString(clean, i)
+			tokens = append(tokens, lyToken{'s', text, i, end})
+			i = end
+		case c == '#' && i+1 < len(clean) && clean[i+1] == '"':
+			text, end := readString(clean, i+1)
+			tokens = append(tokens, lyToken{'s', text, i, end})
+			i = end
+		case c == '#':
+			end := schemeEnd(clean, i+1)
+			tokens = append(tokens, lyToken{'#', string(clean[i:end]), i, end})
+			i = end
+		case c == '\\':
+			end := wordEnd(clean, i+1)
+			tokens = append(tokens, lyToken{'\\', string(clean[i:end]), i, end})
+			i = end
+		default:
+			end := max(i+1, wordEnd(clean, i))
+			tokens = append(tokens, lyToken{'w', string(clean[i:end]), i, end})
+			i = end
+		}
+	}
+
+	return tokens
+}
+
+// readString reads the string starting with the quote at {start} in {src}
+// and returns its unescaped contents and the index after the closing quote.
+func readString(src []byte, start int) (string, int) {
+	var sb strings.Builder
+	i := start + 1
+	for ; i < len(src) && src[i] != '"'; i++ {
+		if src[i] == '\\' && i+1 < len(src) {
+			i++
+			switch src[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			default:
+				sb.WriteByte(src[i])
+			}
+			continue
+		}
+		sb.WriteByte(src[i])
+	}
+
+	return sb.String(), min(i+1, len(src))
+}
+
+// wordEnd returns the index of the first character at or after {start} in
+// {src} that can't be part of a word.
+func wordEnd(src []byte, start int) int {
+	i := start
+	for i < len(src) && !strings.ContainsRune(" \t\r\n{}=\"#\\", rune(src[i])) {
+		i++
+	}
+	return i
+}
+
+// schemeEnd returns the index after the Scheme expression starting at
+// {start} in {src}, which is a parenthesised list, a string or an atom.
+func schemeEnd(src []byte, start int) int {
+	i := start
+	for i < len(src) && (src[i] == '#' || src[i] == '\'' || src[i] == '`') {
+		i++
+	}
+	if i >= len(src) || src[i] != '(' {
+		for i < len(src) && !strings.ContainsRune(" \t\r\n{}", rune(src[i])) {
+			i++
+		}
+		return i
+	}
+
+	depth := 0
+	for ; i < len(src); i++ {
+		switch src[i] {
+		case '"':
+			_, end := readString(src, i)
+			i = end - 1
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return i
+}
+
+// parseHeader returns all fields of the \header blocks in {src}. The fields
+// of the top level header are used first, and fields that are only set in
+// the header of a \bookpart or \score are added from the first such header.
+// String values are unescaped, markup values are reduced to their text and
+// other values, like Scheme expressions, are kept as written.
+func parseHeader(src []byte) map[string]string {
+	tokens := tokenize(src)
+	fields := map[string]string{}
+	nested := map[string]string{}
+	depth := 0
+	for i := 0; i < len(tokens); i++ {
+		switch t := tokens[i]; {
+		case t.kind == '{':
+			depth++
+		case t.kind == '}':
+			depth--
+		case t.kind == '\\' && t.text == `\header` && i+1 < len(tokens) && tokens[i+1].kind == '{':
+			into := fields
+			if depth > 0 {
+				into = nested
+			}
+			i = parseHeaderFields(tokens, i+2, into)
+		}
+	}
+
+	for k, v := range nested {
+		if _, ok := fields[k]; !ok {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// parseHeaderFields reads "name = value" fields from {tokens}, starting at
+// {start} just inside the braces of a header, and adds those not already
+// there to {fields}. It returns the index of the closing brace.
+func parseHeaderFields(tokens []lyToken, start int, fields map[string]string) int {
+	i := start
+	for i < len(tokens) && tokens[i].kind != '}' {
+		if i+2 >= len(tokens) || tokens[i].kind != 'w' || tokens[i+1].kind != '=' {
+			// Not a field, so skip it whole
+			i = skipValue(tokens, i)
+			continue
+		}
+		name := tokens[i].text
+		end := skipValue(tokens, i+2)
+		if _, ok := fields[name]; !ok {
+			fields[name] = valueText(tokens[i+2 : end])
+		}
+		i = end
+	}
+
+	return i
+}
+
+// skipValue returns the index after the value starting at {start} in
+// {tokens}. Braced blocks are skipped whole, and commands like \markup
+// take the values following them, including Scheme arguments.
+func skipValue(tokens []lyToken, start int) int {
+	i := start
+	command := false
+	for i < len(tokens) {
+		t := tokens[i]
+		i++
+		switch t.kind {
+		case '{':
+			for depth := 1; i < len(tokens) && depth > 0; i++ {
+				switch tokens[i].kind {
+				case '{':
+					depth++
+				case '}':
+					depth--
+				}
+			}
+			return i
+		case '\\':
+			// Commands take arguments, so keep going
+			command = true
+		case '#':
+			if !command {
+				return i
+			}
+		case '}':
+			return i - 1
+		default:
+			return i
+		}
+	}
+
+	return i
+}
+
+// escapeLyString escapes {s} for use inside a Lilypond string.
+func escapeLyString(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+// valueText returns the text of the value {tokens}. A single token keeps
+// its text, while markup is reduced to the strings and words in it.
+func valueText(tokens []lyToken) string {
+	if len(tokens) == 1 {
+		return tokens[0].text
+	}
+
+	words := []string{}
+	for _, t := range tokens {
+		if t.kind == 's' || t.kind == 'w' {
+			words = append(words, t.text)
+		}
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want map[string]string
+	}{
+		{"none", `\score { c d e }`, map[string]string{}},
+		{"strings", "\\header {\n  title = \"Tune\"\n  composer = \"Trad.\"\n  meter = \"Reel\"\n}", map[string]string{"title": "Tune", "composer": "Trad.", "meter": "Reel"}},
+		{"escaped_quotes", `\header { title = "The \"Old\" Tune" }`, map[string]string{"title": `The "Old" Tune`}},
+		{"markup", `\header { title = \markup { \bold "Big" Tune } subtitle = \markup \italic "Sub" }`, map[string]string{"title": "Big Tune", "subtitle": "Sub"}},
+		{"markup_scheme_arg", `\header { title = \markup \override #'(font-size . 3) "Tune" }`, map[string]string{"title": "Tune"}},
+		{"scheme", `\header { title = #"Tune" tagline = ##f opus = #(string-append "a" "b") }`, map[string]string{"title": "Tune", "tagline": "##f", "opus": `#(string-append "a" "b")`}},
+		{"custom_field", `\header { mySource = "Book" }`, map[string]string{"mySource": "Book"}},
+		{"commented_out", "% \\header { title = \"Old\" }\n%{ \\header { title = \"Older\" } %}\n\\header { title = \"New\" }", map[string]string{"title": "New"}},
+		{"bookpart", `\bookpart { \header { title = "Part" piece = "Jig" } \score { c } }`, map[string]string{"title": "Part", "piece": "Jig"}},
+		{"top_level_first", `\bookpart { \header { title = "Part" piece = "Jig" } } \header { title = "Book" }`, map[string]string{"title": "Book", "piece": "Jig"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseHeader([]byte(tt.src)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseHeader() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
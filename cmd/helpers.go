@@ -5,17 +5,19 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"maps"
 	"os"
 	"path"
-	"regexp"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"text/template"
 )
 
 const outputDir = "_output"
 
-var titleRx = regexp.MustCompile("title\\s*=\\s*\"(.+)\"")
-
 // printAndReturnError wraps an error with a format string and prints it to stderr before returning it.
 // It uses fmt.Errorf with %w to preserve error wrapping for errors.Is/As checks.
 func printAndReturnError(format string, args ...any) error {
@@ -36,10 +38,13 @@ func getSourcePath(p string) string {
 	return ensureSuffix(pathFromRoot(makeRel(p)), ".ly")
 }
 
-// getTemplatePath returns the full path to a generated template file in
-// the root directory of the music hierarchy.
+// getTemplatePath returns the file name of the generated template for a
+// tune. The name is built from the whole path relative to the music root,
+// so tunes with the same base name in different directories don't share a
+// template file.
 func getTemplatePath(p string) string {
-	return ensureSuffix(pathFromRoot("__"+noExt(path.Base(p))), ".ly")
+	name := strings.ReplaceAll(strings.TrimPrefix(noExt(makeRel(p)), "/"), "/", "_")
+	return ensureSuffix("__"+name, ".ly")
 }
 
 // getPdfPath returns the full path to where the PDF file for a given tune
@@ -63,6 +68,56 @@ func getPreviewPath(p string) string {
 	return ensureSuffix(pathFromRoot(outputDir, noExt(makeRel(p))), ".preview.png")
 }
 
+// getPngPath returns the full path to where the PNG file for a given tune
+// should be stored.
+func getPngPath(p string) string {
+	return ensureSuffix(pathFromRoot(outputDir, noExt(makeRel(p))), ".png")
+}
+
+// getSvgPath returns the full path to where the SVG file for a given tune
+// should be stored. Pages of multi-page output are stored next to it with
+// the page number appended, e.g. _output/tune-2.svg.
+func getSvgPath(p string) string {
+	return ensureSuffix(pathFromRoot(outputDir, noExt(makeRel(p))), ".svg")
+}
+
+// getMidiPath returns the full path to where the MIDI file for a given tune
+// should be stored.
+func getMidiPath(p string) string {
+	return ensureSuffix(pathFromRoot(outputDir, noExt(makeRel(p))), ".midi")
+}
+
+// findPages returns the files named {base}{sep}N{ext}, where N is a page
+// number, ordered by page number. Lilypond names the files of multi-page
+// output like that.
+func findPages(base, sep, ext string) []string {
+	entries, err := os.ReadDir(filepath.Dir(base))
+	if err != nil {
+		return nil
+	}
+
+	prefix := filepath.Base(base) + sep
+	pages := map[int]string{}
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext))
+		if err != nil || n < 1 {
+			continue
+		}
+		pages[n] = filepath.Join(filepath.Dir(base), name)
+	}
+
+	numbers := slices.Sorted(maps.Keys(pages))
+	files := make([]string, len(numbers))
+	for i, n := range numbers {
+		files[i] = pages[n]
+	}
+	return files
+}
+
 // getOutputPath returns the full path to either preview or PDF file depending
 // on the flag {preview}.
 func getOutputPath(p string, preview bool) string {
@@ -72,6 +127,84 @@ func getOutputPath(p string, preview bool) string {
 	return getPdfPath(p)
 }
 
+// expandGlobs expands all arguments containing a '*' relative to the music
+// root. Other arguments are returned as is. A warning is printed for each
+// pattern that doesn't match anything.
+func expandGlobs(args []string) ([]string, error) {
+	files := []string{}
+	for _, arg := range args {
+		if !strings.Contains(arg, "*") {
+			files = append(files, arg)
+			continue
+		}
+		f, err := filepath.Glob(pathFromRoot(arg))
+		if err != nil {
+			return nil, printAndReturnError("failed to expand glob pattern %s: %w", arg, err)
+		}
+		if len(f) == 0 {
+			printWarning("no files matched pattern %s", arg)
+		}
+		files = append(files, f...)
+	}
+
+	return files, nil
+}
+
+// findTunes returns the full paths of all Lilypond files in the music
+// hierarchy, skipping the output directory, hidden directories and
+// generated template files.
+func findTunes() ([]string, error) {
+	return findSources(".ly")
+}
+
+// findSources returns the full paths of all files in the music hierarchy
+// ending with one of {suffixes}. The output directory, hidden files and
+// directories, and generated files and directories are skipped.
+func findSources(suffixes ...string) ([]string, error) {
+	root := pathFromRoot()
+	if root == "" {
+		root = "."
+	}
+	files := []string{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if d.IsDir() {
+			if p != root && (name == outputDir || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "__")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(name, "__") || strings.HasPrefix(name, ".") {
+			return nil
+		}
+		for _, suffix := range suffixes {
+			if strings.HasSuffix(name, suffix) {
+				files = append(files, p)
+				break
+			}
+		}
+		return nil
+	})
+
+	return files, err
+}
+
+// getIncludePaths returns the extra Lilypond include paths set in the
+// configuration. Relative paths are taken to be relative to the music root.
+func getIncludePaths() []string {
+	paths := []string{}
+	for _, p := range GetConfig().IncludePaths {
+		if p != "" {
+			paths = append(paths, pathFromRoot(p))
+		}
+	}
+
+	return paths
+}
+
 // getEditor returns the editor set in the configuration file or exported from
 // the shell. It returns the editor name and an array of arguments so it can
 // easily be slotted in to exec.Command.
@@ -178,3 +311,38 @@ func copyFile(src, dst string) (int64, error) {
 	nBytes, err := io.Copy(destination, source)
 	return nBytes, err
 }
+
+// moveFile moves the file {src} to {dst}. If they are on different file
+// systems, the file is copied and the original removed.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if _, err := copyFile(src, dst); err != nil {
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+// copyDir copies all regular files in the directory {src} to the directory
+// {dst}, which is created if needed. Subdirectories are not copied.
+func copyDir(src, dst string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		if _, err := copyFile(filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
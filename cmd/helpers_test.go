@@ -54,7 +54,9 @@ func Test_getTemplatePath(t *testing.T) {
 		want string
 	}{
 		{"simple_path", args{"song.ly"}, "__song.ly"},
-		{"path_with_directory", args{"/music/folk/song.ly"}, "__song.ly"},
+		{"path_with_directory", args{"folk/song.ly"}, "__folk_song.ly"},
+		{"absolute_path", args{"/music/folk/song.ly"}, "__music_folk_song.ly"},
+		{"same_base_other_directory", args{"jigs/song.ly"}, "__jigs_song.ly"},
 		{"path_with_multiple_extensions", args{"song.mid.ly"}, "__song.ly"},
 		{"path_without_extension", args{"song"}, "__song.ly"},
 		{"empty_path", args{""}, "__.ly"},
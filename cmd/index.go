@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const libraryIndexFile = ".domusic-index.json"
+
+// libraryIndex remembers the header fields, includes, content hash and
+// output files of every Lilypond file in the music hierarchy, keyed on the
+// file's path relative to the music root. It is stored in the output
+// directory and brought up to date with refresh or get, which only read the
+// files that changed since the last time.
+type libraryIndex struct {
+	path    string
+	changed bool
+	Tunes   map[string]indexEntry `json:"tunes"`
+}
+
+// indexEntry is what the index knows about a single file. {Size} and
+// {Modified} tell if the file needs to be read again, and {Hash} if it has
+// to be parsed again. {Dependencies} are the files the generated document
+// with the hash {DocHash} includes, as found at the time {Checked}.
+type indexEntry struct {
+	tuneInfo
+	Size         int64            `json:"size"`
+	Hash         string           `json:"hash"`
+	Includes     []string         `json:"includes"`
+	DocHash      string           `json:"docHash,omitempty"`
+	Dependencies []string         `json:"dependencies,omitempty"`
+	Missing      []missingInclude `json:"missing,omitempty"`
+	Checked      time.Time        `json:"checked,omitzero"`
+}
+
+// missingInclude is an include that couldn't be resolved. {Dir} is the
+// directory it is resolved from, relative to the music root.
+type missingInclude struct {
+	Name string `json:"name"`
+	Dir  string `json:"dir"`
+}
+
+// loadLibraryIndex reads the library index from the output directory. A
+// missing or unreadable index file results in an empty index.
+func loadLibraryIndex() *libraryIndex {
+	idx := &libraryIndex{
+		path:  pathFromRoot(outputDir, libraryIndexFile),
+		Tunes: map[string]indexEntry{},
+	}
+	if data, err := os.ReadFile(idx.path); err == nil {
+		if err := json.Unmarshal(data, idx); err != nil {
+			printWarning("ignoring broken library index %s: %w", idx.path, err)
+		}
+	}
+	if idx.Tunes == nil {
+		idx.Tunes = map[string]indexEntry{}
+	}
+
+	return idx
+}
+
+// refresh brings the index up to date with all Lilypond files in the music
+// hierarchy and returns their entries in the order they were found. Files
+// that are gone are removed from the index, and files that can't be read
+// are skipped with a warning.
+func (idx *libraryIndex) refresh() ([]indexEntry, error) {
+	files, err := findTunes()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []indexEntry{}
+	seen := map[string]bool{}
+	for _, f := range files {
+		entry, err := idx.get(f)
+		if err != nil {
+			printWarning("skipping %s: %w", makeRel(f), err)
+			continue
+		}
+		entries = append(entries, entry)
+		seen[entry.Path] = true
+	}
+	for p := range idx.Tunes {
+		if !seen[p] {
+			delete(idx.Tunes, p)
+			idx.changed = true
+		}
+	}
+
+	return entries, nil
+}
+
+// get returns the entry for the file {src}, bringing it up to date first.
+// The file is only read if its size or modification time changed, and only
+// parsed if its contents did.
+func (idx *libraryIndex) get(src string) (indexEntry, error) {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return indexEntry{}, err
+	}
+
+	key := makeRel(src)
+	entry, ok := idx.Tunes[key]
+	if !ok || entry.Size != fi.Size() || !entry.Modified.Equal(fi.ModTime()) {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return indexEntry{}, err
+		}
+		if hash := hashBytes(data); !ok || entry.Hash != hash {
+			entry = indexEntry{
+				tuneInfo: tuneInfo{Path: key, Header: parseHeader(data)},
+				Hash:     hash,
+				Includes: parseIncludes(data),
+			}
+		}
+		entry.Size, entry.Modified = fi.Size(), fi.ModTime()
+		idx.changed = true
+	}
+	outputs := findOutputs(src)
+	if !slices.Equal(outputs, entry.Outputs) {
+		idx.changed = true
+	}
+	entry.Outputs = outputs
+	idx.Tunes[key] = entry
+
+	return entry, nil
+}
+
+// dependencies returns the full paths of all files that the document
+// generated by {m} for {src} includes, like maker.dependencies does. The
+// includes are only searched again if the document, the include paths or
+// any of the files it included before changed, or if an include that
+// couldn't be resolved before can be now.
+func (idx *libraryIndex) dependencies(m *maker, src string) ([]string, error) {
+	entry, err := idx.get(src)
+	if err != nil {
+		return nil, err
+	}
+	doc, _, err := m.renderTemplate(src, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Where includes are found depends on the include paths too
+	docHash := hashBytes([]byte(doc + "\n" + strings.Join(getIncludePaths(), "\n")))
+	if entry.DocHash == docHash && !changedSince(entry.Dependencies, entry.Checked) && !anyResolved(entry.Missing) {
+		deps := []string{}
+		for _, dep := range entry.Dependencies {
+			deps = append(deps, pathFromRoot(dep))
+		}
+		return deps, nil
+	}
+
+	checked := time.Now()
+	deps, missing := scanDependencies(src, []byte(doc))
+	entry.DocHash, entry.Checked, entry.Dependencies, entry.Missing = docHash, checked, []string{}, missing
+	for _, dep := range deps {
+		entry.Dependencies = append(entry.Dependencies, makeRel(dep))
+	}
+	idx.Tunes[entry.Path] = entry
+	idx.changed = true
+
+	return deps, nil
+}
+
+// changedSince reports whether any of the files {paths}, relative to the
+// music root, is gone or was modified after {t}.
+func changedSince(paths []string, t time.Time) bool {
+	for _, p := range paths {
+		fi, err := os.Stat(pathFromRoot(p))
+		if err != nil || fi.ModTime().After(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// anyResolved reports whether any of the includes {missing} can be
+// resolved now.
+func anyResolved(missing []missingInclude) bool {
+	return slices.ContainsFunc(missing, func(mi missingInclude) bool {
+		return resolveInclude(mi.Name, pathFromRoot(mi.Dir)) != ""
+	})
+}
+
+// hashBytes returns the hex encoded SHA-256 hash of {data}.
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// save writes the index back to the output directory if anything changed.
+func (idx *libraryIndex) save() error {
+	if !idx.changed {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(idx.path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(idx.path, data, 0644); err != nil {
+		return err
+	}
+	idx.changed = false
+
+	return nil
+}
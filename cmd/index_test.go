@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_libraryIndex(t *testing.T) {
+	root := t.TempDir()
+	resetConfigForTest()
+	t.Cleanup(resetConfigForTest)
+	GetConfig().Root = root
+
+	write := func(name, content string) {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("jigs/old.ly", "\\include \"bagpipe.ly\"\n\\header { title = \"Old Jig\" }")
+	write("reels/new.ly", `\header { title = "New Reel" }`)
+
+	idx := loadLibraryIndex()
+	entries, err := idx.refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Header["title"] != "Old Jig" || !reflect.DeepEqual(entries[0].Includes, []string{"bagpipe.ly"}) {
+		t.Fatalf("refresh() = %+v", entries)
+	}
+	if err := idx.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unchanged files are not parsed again
+	idx = loadLibraryIndex()
+	entry := idx.Tunes["jigs/old.ly"]
+	entry.Header["title"] = "Cached"
+	idx.Tunes["jigs/old.ly"] = entry
+	if entry, _ := idx.get(filepath.Join(root, "jigs/old.ly")); entry.Header["title"] != "Cached" || idx.changed {
+		t.Errorf("get() of unchanged file = %q, changed %t", entry.Header["title"], idx.changed)
+	}
+
+	// Changed and removed files are picked up
+	write("jigs/old.ly", `\header { title = "Older Jig" }`)
+	os.Chtimes(filepath.Join(root, "jigs/old.ly"), time.Now(), time.Now().Add(time.Minute))
+	os.Remove(filepath.Join(root, "reels/new.ly"))
+	write("_output/jigs/old.pdf", "")
+	entries, err = idx.refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Header["title"] != "Older Jig" || !reflect.DeepEqual(entries[0].Outputs, []string{"_output/jigs/old.pdf"}) {
+		t.Errorf("refresh() after changes = %+v", entries)
+	}
+	if _, ok := idx.Tunes["reels/new.ly"]; ok || !idx.changed {
+		t.Errorf("refresh() kept removed file, changed %t", idx.changed)
+	}
+}
+
+func Test_changedSince(t *testing.T) {
+	root := t.TempDir()
+	resetConfigForTest()
+	t.Cleanup(resetConfigForTest)
+	GetConfig().Root = root
+
+	if err := os.WriteFile(filepath.Join(root, "common.ily"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	tests := []struct {
+		name  string
+		paths []string
+		t     time.Time
+		want  bool
+	}{
+		{"none", []string{}, now, false},
+		{"unchanged", []string{"common.ily"}, now.Add(time.Minute), false},
+		{"modified", []string{"common.ily"}, now.Add(-time.Hour), true},
+		{"missing", []string{"gone.ily"}, now, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := changedSince(tt.paths, tt.t); got != tt.want {
+				t.Errorf("changedSince() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_libraryIndex_dependencies(t *testing.T) {
+	root := t.TempDir()
+	resetConfigForTest()
+	t.Cleanup(resetConfigForTest)
+	GetConfig().Root = root
+
+	write := func(name string) {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("% "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src := filepath.Join(root, "jigs/tune.ly")
+	if err := os.MkdirAll(filepath.Dir(src), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(src, []byte("\\include \"defs.ily\"\n\\include \"extra.ily\""), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := &maker{document: true}
+	idx := loadLibraryIndex()
+
+	tests := []struct {
+		name  string
+		setup func()
+		want  []string
+	}{
+		{"unresolved", func() {}, []string{}},
+		{"include_added", func() { write("defs.ily") }, []string{"defs.ily"}},
+		{"include_path_added", func() {
+			write("inc/extra.ily")
+			GetConfig().IncludePaths = []string{"inc"}
+		}, []string{"defs.ily", "inc/extra.ily"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setup()
+			deps, err := idx.dependencies(m, src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, d := range deps {
+				got = append(got, makeRel(d))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dependencies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
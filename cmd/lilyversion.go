@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	versionStatementRx = regexp.MustCompile(`\\version\s*"([0-9.]+)"`)
+	versionNumberRx    = regexp.MustCompile(`[0-9]+\.[0-9]+(\.[0-9]+)?`)
+)
+
+// sourceVersion returns the version given by the \version statement in
+// {src} and the line it is on, or an empty string if there is none.
+func sourceVersion(src []byte) (string, int) {
+	clean := stripComments(src)
+	loc := versionStatementRx.FindSubmatchIndex(clean)
+	if loc == nil {
+		return "", 0
+	}
+
+	return string(clean[loc[2]:loc[3]]), bytes.Count(clean[:loc[0]], []byte("\n")) + 1
+}
+
+// installedVersion returns the version number of the Lilypond run by
+// {runner}, or an empty string if it can't be found out.
+func installedVersion(ctx context.Context, runner lilypondRunner) string {
+	v, err := runner.version(ctx)
+	if err != nil {
+		return ""
+	}
+
+	return versionNumberRx.FindString(v)
+}
+
+// compareVersions compares the version numbers {a} and {b} part by part,
+// and returns -1, 0 or 1 like strings.Compare. Missing parts count as 0.
+func compareVersions(a, b string) int {
+	pa, pb := strings.Split(a, "."), strings.Split(b, ".")
+	for i := range max(len(pa), len(pb)) {
+		var na, nb int
+		if i < len(pa) {
+			na, _ = strconv.Atoi(pa[i])
+		}
+		if i < len(pb) {
+			nb, _ = strconv.Atoi(pb[i])
+		}
+		if na != nb {
+			if na < nb {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// majorMinor returns the first two parts of the version {v}, which is what
+// changes when Lilypond syntax changes.
+func majorMinor(v string) string {
+	parts := strings.SplitN(v, ".", 3)
+	return strings.Join(parts[:min(2, len(parts))], ".")
+}
+
+// checkVersion compares the version declared in the tune {src} with the
+// installed Lilypond version {installed}, and returns a warning if the tune
+// needs a newer Lilypond or is written for an older one. It returns nil if
+// the versions match or either of them is unknown.
+func checkVersion(src string, data []byte, installed string) *diagnostic {
+	declared, line := sourceVersion(data)
+	if declared == "" || installed == "" {
+		return nil
+	}
+
+	msg := ""
+	switch {
+	case compareVersions(declared, installed) > 0:
+		msg = fmt.Sprintf("tune needs Lilypond %s, but %s is installed", declared, installed)
+	case compareVersions(majorMinor(declared), majorMinor(installed)) < 0:
+		msg = fmt.Sprintf("tune is written for Lilypond %s, but %s is installed; use domusic upgrade to update it", declared, installed)
+	default:
+		return nil
+	}
+
+	return &diagnostic{File: src, Line: line, Severity: "warning", Message: msg}
+}
@@ -0,0 +1,239 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+var listCmd = &cli.Command{
+	Name:    "list",
+	Aliases: []string{"search"},
+	Usage:   "List the tunes in the music library, optionally filtered by their header fields",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "composer",
+			Usage: "only tunes whose composer contains {text}",
+		},
+		&cli.StringFlag{
+			Name:  "type",
+			Usage: "only tunes whose meter field, like jig or reel, contains {text}",
+		},
+		&cli.StringFlag{
+			Name:  "title",
+			Usage: "only tunes whose title matches the regular expression {regexp} or contains it as plain text",
+		},
+		&cli.BoolFlag{
+			Name:  "has-output",
+			Usage: "only tunes with files in the output directory, or without any with --has-output=false",
+		},
+		&cli.StringFlag{
+			Name:  "modified-since",
+			Usage: "only tunes changed after {time}, a date like 2025-01-31 or a duration like 48h",
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Value:   "table",
+			Usage:   "print the tunes as a table, as paths for make or collection, or as json",
+		},
+	},
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		output := cmd.String("output")
+		if output != "table" && output != "paths" && output != "json" {
+			return printAndReturnError("unknown output %s, use table, paths or json", output)
+		}
+		query := tuneQuery{
+			Composer:      cmd.String("composer"),
+			Type:          cmd.String("type"),
+			Title:         cmd.String("title"),
+			ModifiedSince: cmd.String("modified-since"),
+		}
+		if cmd.IsSet("has-output") {
+			hasOutput := cmd.Bool("has-output")
+			query.HasOutput = &hasOutput
+		}
+		filter, err := newTuneFilter(query, time.Now())
+		if err != nil {
+			return printAndReturnError("%w", err)
+		}
+
+		tunes, err := listTunes(filter)
+		if err != nil {
+			return printAndReturnError("failed to list tunes: %w", err)
+		}
+		return printTunes(os.Stdout, tunes, output)
+	},
+}
+
+// tuneInfo is what is known about a tune in the music library. {Path} is
+// relative to the music root, as are the paths of the output files.
+type tuneInfo struct {
+	Path     string            `json:"path"`
+	Header   map[string]string `json:"header"`
+	Modified time.Time         `json:"modified"`
+	Outputs  []string          `json:"outputs"`
+}
+
+// findOutputs returns the paths, relative to the music root, of all output
+// files of the tune {src} that exist, including the pages of multi-page
+// output and the MIDI files of later scores.
+func findOutputs(src string) []string {
+	outputs := []string{}
+	for _, p := range []string{getPdfPath(src), getPngPath(src), getSvgPath(src), getMidiPath(src)} {
+		if _, err := os.Stat(p); err == nil {
+			outputs = append(outputs, makeRel(p))
+		}
+	}
+	for _, p := range []string{getPngPath(src), getSvgPath(src)} {
+		for _, page := range findPages(noExt(p), "-page", filepath.Ext(p)) {
+			outputs = append(outputs, makeRel(page))
+		}
+	}
+	for _, score := range findPages(noExt(getMidiPath(src)), "-score", ".midi") {
+		outputs = append(outputs, makeRel(score))
+	}
+
+	return outputs
+}
+
+// listTunes returns all tunes in the music hierarchy that {filter} lets
+// through, using the library index. Files without a title, like include
+// files, are not tunes and are left out.
+func listTunes(filter *tuneFilter) ([]tuneInfo, error) {
+	idx := loadLibraryIndex()
+	entries, err := idx.refresh()
+	if err != nil {
+		return nil, err
+	}
+	if err := idx.save(); err != nil {
+		printWarning("failed to save library index: %w", err)
+	}
+
+	tunes := []tuneInfo{}
+	for _, e := range entries {
+		if e.Header["title"] != "" && filter.match(e.tuneInfo) {
+			tunes = append(tunes, e.tuneInfo)
+		}
+	}
+
+	return tunes, nil
+}
+
+// printTunes writes {tunes} to {w} as a table, one path per line, or JSON,
+// as given by {output}.
+func printTunes(w io.Writer, tunes []tuneInfo, output string) error {
+	switch output {
+	case "paths":
+		for _, t := range tunes {
+			fmt.Fprintln(w, t.Path)
+		}
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(tunes)
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "PATH\tTITLE\tCOMPOSER\tTYPE")
+		for _, t := range tunes {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", t.Path, t.Header["title"], t.Header["composer"], t.Header["meter"])
+		}
+		return tw.Flush()
+	}
+
+	return nil
+}
+
+// tuneFilter selects tunes by their header fields, output files and
+// modification time. Empty fields match everything. A title matches if it
+// matches {title} or contains {titleText}, so plain text can be given even
+// if it has characters with a special meaning in regular expressions.
+type tuneFilter struct {
+	composer  string
+	meter     string
+	title     *regexp.Regexp
+	titleText string
+	hasOutput *bool
+	since     time.Time
+}
+
+// tuneQuery describes which tunes to select, as given to the list command
+// or in a collection manifest. The fields work like the flags of the list
+// command with the same names.
+type tuneQuery struct {
+	Composer      string `yaml:"composer"`
+	Type          string `yaml:"type"`
+	Title         string `yaml:"title"`
+	HasOutput     *bool  `yaml:"has-output"`
+	ModifiedSince string `yaml:"modified-since"`
+}
+
+// newTuneFilter creates a filter for {query}. Durations given as the
+// modification time are counted back from {now}.
+func newTuneFilter(query tuneQuery, now time.Time) (*tuneFilter, error) {
+	f := &tuneFilter{
+		composer:  strings.ToLower(query.Composer),
+		meter:     strings.ToLower(query.Type),
+		hasOutput: query.HasOutput,
+	}
+	if query.Title != "" {
+		rx, err := regexp.Compile("(?i)" + query.Title)
+		if err != nil {
+			rx = regexp.MustCompile("(?i)" + regexp.QuoteMeta(query.Title))
+		}
+		f.title = rx
+		f.titleText = strings.ToLower(query.Title)
+	}
+	if since := query.ModifiedSince; since != "" {
+		t, err := parseSince(since, now)
+		if err != nil {
+			return nil, err
+		}
+		f.since = t
+	}
+
+	return f, nil
+}
+
+// parseSince parses {s} as a date, a date and time, or a duration before
+// {now}.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.DateOnly, "2006-01-02T15:04", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %s, use a date like 2025-01-31 or a duration like 48h", s)
+}
+
+// match reports whether {t} passes the filter.
+func (f *tuneFilter) match(t tuneInfo) bool {
+	switch {
+	case f.composer != "" && !strings.Contains(strings.ToLower(t.Header["composer"]), f.composer):
+		return false
+	case f.meter != "" && !strings.Contains(strings.ToLower(t.Header["meter"]), f.meter):
+		return false
+	case f.title != nil && !f.title.MatchString(t.Header["title"]) &&
+		(f.titleText == "" || !strings.Contains(strings.ToLower(t.Header["title"]), f.titleText)):
+		return false
+	case f.hasOutput != nil && *f.hasOutput != (len(t.Outputs) > 0):
+		return false
+	case !f.since.IsZero() && !t.Modified.After(f.since):
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parseSince(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		name    string
+		s       string
+		want    time.Time
+		wantErr bool
+	}{
+		{"date", "2025-01-31", time.Date(2025, 1, 31, 0, 0, 0, 0, time.Local), false},
+		{"date_time", "2025-01-31T08:30", time.Date(2025, 1, 31, 8, 30, 0, 0, time.Local), false},
+		{"duration", "48h", now.Add(-48 * time.Hour), false},
+		{"invalid", "last week", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSince(tt.s, now)
+			if (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+				t.Errorf("parseSince() = %v, %v, want %v, error %t", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_tuneFilter_match(t *testing.T) {
+	yes, no := true, false
+	tune := tuneInfo{
+		Path:     "jigs/tune.ly",
+		Header:   map[string]string{"title": "The Old Jig", "composer": "Trad.", "meter": "Jig"},
+		Modified: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC),
+		Outputs:  []string{"_output/jigs/tune.pdf"},
+	}
+	tests := []struct {
+		name   string
+		filter tuneFilter
+		want   bool
+	}{
+		{"empty", tuneFilter{}, true},
+		{"composer", tuneFilter{composer: "trad"}, true},
+		{"other_composer", tuneFilter{composer: "scott"}, false},
+		{"type", tuneFilter{meter: "jig"}, true},
+		{"other_type", tuneFilter{meter: "reel"}, false},
+		{"title", tuneFilter{title: regexp.MustCompile("(?i)^the .* jig$")}, true},
+		{"other_title", tuneFilter{title: regexp.MustCompile("(?i)reel")}, false},
+		{"has_output", tuneFilter{hasOutput: &yes}, true},
+		{"no_output", tuneFilter{hasOutput: &no}, false},
+		{"modified_since", tuneFilter{since: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}, true},
+		{"not_modified_since", tuneFilter{since: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.match(tune); got != tt.want {
+				t.Errorf("match() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newTuneFilter_title(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		tuneTitle string
+		want      bool
+	}{
+		{"regexp", "^the .* jig", "The Old Jig", true},
+		{"plain_text", "Reel (No. 2)", "Jig and Reel (No. 2)", true},
+		{"invalid_regexp", "(No. 2", "Jig and Reel (No. 2)", true},
+		{"other", "Reel (No. 3)", "Jig and Reel (No. 2)", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newTuneFilter(tuneQuery{Title: tt.query}, time.Now())
+			if err != nil {
+				t.Fatalf("newTuneFilter() failed: %v", err)
+			}
+			tune := tuneInfo{Header: map[string]string{"title": tt.tuneTitle}}
+			if got := f.match(tune); got != tt.want {
+				t.Errorf("match() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_listTunes(t *testing.T) {
+	root := t.TempDir()
+	resetConfigForTest()
+	t.Cleanup(resetConfigForTest)
+	GetConfig().Root = root
+
+	files := map[string]string{
+		"header_default.ly":          `\paper { }`,
+		"jigs/old.ly":                `\header { title = "Old Jig" meter = "Jig" }`,
+		"reels/new.ly":               `\header { title = "New Reel" meter = "Reel" }`,
+		"reels/new-2.ly":             `\header { title = "New Reel (No. 2)" meter = "Reel" }`,
+		"_output/jigs/old.pdf":       "",
+		"_output/jigs/old-page1.svg": "",
+		"_output/reels/new-2.png":    "",
+	}
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tunes, err := listTunes(&tuneFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths bytes.Buffer
+	printTunes(&paths, tunes, "paths")
+	if got, want := paths.String(), "jigs/old.ly\nreels/new-2.ly\nreels/new.ly\n"; got != want {
+		t.Errorf("paths = %q, want %q", got, want)
+	}
+	if got, want := strings.Join(tunes[0].Outputs, ","), "_output/jigs/old.pdf,_output/jigs/old-page1.svg"; got != want {
+		t.Errorf("outputs = %q, want %q", got, want)
+	}
+
+	if got := tunes[2].Outputs; len(got) != 0 {
+		t.Errorf("outputs of another tune should not be pages: %v", got)
+	}
+
+	tunes, _ = listTunes(&tuneFilter{meter: "reel", hasOutput: new(bool)})
+	if len(tunes) != 1 || tunes[0].Header["title"] != "New Reel" {
+		t.Errorf("listTunes() with type filter = %v", tunes)
+	}
+}
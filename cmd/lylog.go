@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+var (
+	diagnosticRx         = regexp.MustCompile(`^(.+?):(\d+):(\d+): (error|warning|programming error|fatal error): (.*)$`)
+	globalDiagnosticRx   = regexp.MustCompile(`^(error|warning|programming error|fatal error): (.*)$`)
+	lilypondFailedFileRx = regexp.MustCompile(`^fatal error: failed files:`)
+)
+
+// diagnostic is a single error or warning reported by Lilypond.
+type diagnostic struct {
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// String formats the diagnostic the way compilers do, so editors can jump
+// to the location.
+func (d diagnostic) String() string {
+	if d.File == "" {
+		return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s: %s", d.File, d.Line, d.Column, d.Severity, d.Message)
+}
+
+// isError reports whether the diagnostic is anything worse than a warning.
+func (d diagnostic) isError() bool {
+	return d.Severity != "warning"
+}
+
+// parseLilypondLog extracts all diagnostics from Lilypond's output. The
+// source excerpts Lilypond prints after each message are skipped, as is
+// the summary line listing the failed files.
+func parseLilypondLog(log []byte) []diagnostic {
+	diags := []diagnostic{}
+	scanner := bufio.NewScanner(bytes.NewReader(log))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := diagnosticRx.FindStringSubmatch(line); m != nil {
+			lineNo, _ := strconv.Atoi(m[2])
+			col, _ := strconv.Atoi(m[3])
+			diags = append(diags, diagnostic{
+				File:     m[1],
+				Line:     lineNo,
+				Column:   col,
+				Severity: m[4],
+				Message:  m[5],
+			})
+		} else if m := globalDiagnosticRx.FindStringSubmatch(line); m != nil && !lilypondFailedFileRx.MatchString(line) {
+			diags = append(diags, diagnostic{Severity: m[1], Message: m[2]})
+		}
+	}
+
+	return diags
+}
+
+// mapDiagnostics rewrites the locations of all diagnostics in the generated
+// file {template} so they point at the tune {src} instead. {lineMap} gives
+// the source line for each template line, with 0 for lines that come from
+// the make template. Those are left pointing at the generated file.
+func mapDiagnostics(diags []diagnostic, template, src string, lineMap []int) []diagnostic {
+	mapped := make([]diagnostic, len(diags))
+	for i, d := range diags {
+		if d.File != "" && filepath.Clean(d.File) == filepath.Clean(template) &&
+			d.Line > 0 && d.Line <= len(lineMap) && lineMap[d.Line-1] > 0 {
+			d.File = src
+			d.Line = lineMap[d.Line-1]
+		}
+		mapped[i] = d
+	}
+
+	return mapped
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testLilypondLog = `GNU LilyPond 2.24.3 (running Guile 2.2)
+Processing ` + "`/music/__jigs_tune.ly'" + `
+Parsing...
+/music/__jigs_tune.ly:24:5: error: syntax error, unexpected '}'
+  c4 d
+    }
+/music/header_default.ly:3:1: warning: no \version statement found
+Interpreting music...
+warning: no music found in score
+fatal error: failed files: "/music/__jigs_tune.ly"
+`
+
+func Test_parseLilypondLog(t *testing.T) {
+	want := []diagnostic{
+		{File: "/music/__jigs_tune.ly", Line: 24, Column: 5, Severity: "error", Message: "syntax error, unexpected '}'"},
+		{File: "/music/header_default.ly", Line: 3, Column: 1, Severity: "warning", Message: `no \version statement found`},
+		{Severity: "warning", Message: "no music found in score"},
+	}
+	if got := parseLilypondLog([]byte(testLilypondLog)); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseLilypondLog() = %v, want %v", got, want)
+	}
+}
+
+func Test_mapDiagnostics(t *testing.T) {
+	// Two template lines, then source lines 1-2, a skipped region, and line 6
+	lineMap := []int{0, 0, 1, 2, 6}
+	diags := []diagnostic{
+		{File: "/music/__jigs_tune.ly", Line: 5, Column: 3, Severity: "error", Message: "a"},
+		{File: "/music/__jigs_tune.ly", Line: 1, Column: 1, Severity: "error", Message: "b"},
+		{File: "/music/header_default.ly", Line: 5, Column: 1, Severity: "warning", Message: "c"},
+		{Severity: "warning", Message: "d"},
+	}
+	want := []diagnostic{
+		{File: "/music/jigs/tune.ly", Line: 6, Column: 3, Severity: "error", Message: "a"},
+		{File: "/music/__jigs_tune.ly", Line: 1, Column: 1, Severity: "error", Message: "b"},
+		{File: "/music/header_default.ly", Line: 5, Column: 1, Severity: "warning", Message: "c"},
+		{Severity: "warning", Message: "d"},
+	}
+	got := mapDiagnostics(diags, "/music/__jigs_tune.ly", "/music/jigs/tune.ly", lineMap)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mapDiagnostics() = %v, want %v", got, want)
+	}
+}
+
+func Test_diagnosticString(t *testing.T) {
+	tests := []struct {
+		name string
+		d    diagnostic
+		want string
+	}{
+		{"with_location", diagnostic{"tune.ly", 3, 7, "error", "oops"}, "tune.ly:3:7: error: oops"},
+		{"without_location", diagnostic{Severity: "warning", Message: "hmm"}, "warning: hmm"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.d.String(); got != tt.want {
+				t.Errorf("diagnostic.String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
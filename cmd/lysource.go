@@ -0,0 +1,534 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	scoreRx  = regexp.MustCompile(`\\score\s*\{`)
+	midiRx   = regexp.MustCompile(`\\midi\b`)
+	layoutRx = regexp.MustCompile(`\\layout\s*\{`)
+	pitchRx  = regexp.MustCompile(`^[a-g](is|es|s)*[',]*$`)
+	// A declared list of parts, like "%% parts: melody, seconds"
+	partsRx = regexp.MustCompile(`(?m)^%+[ \t]*parts:(.*)$`)
+	// A part by convention, like "secondsPart = { ... }"
+	partVarRx = regexp.MustCompile(`(?m)^([a-zA-Z]+)Part\s*=`)
+	// A region marker, like "%%% START SKIP preview" or "%%% END ONLY"
+	regionRx = regexp.MustCompile(`^\s*%%%\s*(START|END)\s+(SKIP|ONLY)\b(.*)$`)
+)
+
+// Commands at the top level of a file that are not music. They are followed
+// by a block, like \header, or by a value, like \version.
+var topLevelCommands = []string{
+	`\header`, `\paper`, `\layout`, `\midi`, `\score`, `\book`, `\bookpart`,
+	`\markup`, `\markuplist`, `\version`, `\include`, `\language`,
+}
+
+// block is the position of a braced block in a Lilypond source, like the
+// body of a \score. {start} is the index of the command starting the block,
+// {open} the index of its opening brace and {close} the index of the
+// matching closing brace.
+type block struct {
+	start, open, close int
+}
+
+// findScores returns the positions of all \score blocks in {src}.
+// Scores inside comments are ignored, as are scores that are never closed.
+func findScores(src []byte) []block {
+	clean := stripComments(src)
+	blocks := []block{}
+	pos := 0
+	for {
+		loc := scoreRx.FindIndex(clean[pos:])
+		if loc == nil {
+			break
+		}
+		start, open := pos+loc[0], pos+loc[1]-1
+		if inString(clean, start) {
+			pos = start + 1
+			continue
+		}
+		end := matchBrace(clean, open)
+		if end < 0 {
+			break
+		}
+		blocks = append(blocks, block{start, open, end})
+		pos = end + 1
+	}
+
+	return blocks
+}
+
+// matchBrace returns the index of the brace closing the one at {open} in
+// {clean}, which must have its comments stripped. Braces inside strings are
+// skipped. It returns -1 if there is no matching brace.
+func matchBrace(clean []byte, open int) int {
+	depth := 0
+	for i := open; i < len(clean); i++ {
+		switch clean[i] {
+		case '"':
+			for i++; i < len(clean) && clean[i] != '"'; i++ {
+				if clean[i] == '\\' {
+					i++
+				}
+			}
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// inString reports whether the index {pos} in {clean} is inside a string.
+func inString(clean []byte, pos int) bool {
+	in := false
+	for i := 0; i < pos && i < len(clean); i++ {
+		switch {
+		case in && clean[i] == '\\':
+			i++
+		case clean[i] == '"':
+			in = !in
+		}
+	}
+
+	return in
+}
+
+// allScores returns {src} with the music at its top level wrapped in
+// \score blocks, and the positions of all scores in it. Lilypond makes a
+// score of such music anyway, and this way it can be changed like any other
+// score. Nothing is added on lines of its own, so line numbers don't change.
+func allScores(src []byte) ([]byte, []block) {
+	var out bytes.Buffer
+	pos := 0
+	for _, m := range findTopLevelMusic(src) {
+		out.Write(src[pos:m[0]])
+		out.WriteString(`\score { `)
+		out.Write(src[m[0]:m[1]])
+		out.WriteString(` }`)
+		pos = m[1]
+	}
+	out.Write(src[pos:])
+	src = out.Bytes()
+
+	return src, findScores(src)
+}
+
+// findTopLevelMusic returns the start and end of each music expression at
+// the top level of {src}, like "\relative c' { ... }". Assignments, blocks
+// like \header and \score, and markup are not music. Neither are commands
+// without any braced or simultaneous music after them, which are most
+// likely settings like \pointAndClickOff.
+func findTopLevelMusic(src []byte) [][2]int {
+	tokens := tokenize(src)
+	found := [][2]int{}
+	for i := 0; i < len(tokens); {
+		t := tokens[i]
+		switch {
+		case isAssignment(tokens, i):
+			i = skipAssignment(tokens, i)
+		case t.kind == '\\' && slices.Contains(topLevelCommands, t.text):
+			i = skipValue(tokens, i)
+		case t.kind == '{' || t.kind == '\\' || isWord(t, "<<"):
+			end, music := skipMusic(tokens, i)
+			if music {
+				found = append(found, [2]int{t.start, tokens[end-1].end})
+			}
+			i = max(end, i+1)
+		default:
+			i++
+		}
+	}
+
+	return found
+}
+
+// isAssignment reports whether a variable is assigned at {i} in {tokens}.
+// Context names, like in \new Staff = "melody", look the same but are not
+// assignments.
+func isAssignment(tokens []lyToken, i int) bool {
+	if i+1 >= len(tokens) || tokens[i].kind != 'w' || tokens[i+1].kind != '=' {
+		return false
+	}
+	return i == 0 || (tokens[i-1].text != `\new` && tokens[i-1].text != `\context`)
+}
+
+// skipAssignment returns the index after the assignment at {start} in
+// {tokens}. The value is music, markup or a single token.
+func skipAssignment(tokens []lyToken, start int) int {
+	i := start + 2
+	switch {
+	case i >= len(tokens):
+		return i
+	case tokens[i].text == `\markup` || tokens[i].text == `\markuplist`:
+		return skipValue(tokens, i)
+	case tokens[i].kind == '{' || tokens[i].kind == '\\' || isWord(tokens[i], "<<"):
+		end, _ := skipMusic(tokens, i)
+		return max(end, i+1)
+	}
+
+	return i + 1
+}
+
+// skipMusic returns the index after the music expression starting at
+// {start} in {tokens}: the commands and arguments leading up to a braced or
+// simultaneous block, the block, and anything attached to it, like the
+// lyrics of \addlyrics. It stops early at the next top level statement, in
+// which case the second return value is false, as no music was found.
+func skipMusic(tokens []lyToken, start int) (int, bool) {
+	attached := []string{`\with`, `\addlyrics`, `\lyricsto`}
+	music := false
+	i := start
+	for i < len(tokens) {
+		t := tokens[i]
+		switch {
+		case t.kind == '}' || isAssignment(tokens, i) || (t.kind == '\\' && slices.Contains(topLevelCommands, t.text)):
+			return i, music
+		case t.kind == '{':
+			with := i > start && tokens[i-1].text == `\with`
+			i = skipValue(tokens, i)
+			if with {
+				continue
+			}
+		case isWord(t, "<<"):
+			i = skipSimultaneous(tokens, i)
+		default:
+			i++
+			continue
+		}
+
+		music = true
+		if i >= len(tokens) || !slices.Contains(attached, tokens[i].text) {
+			return i, music
+		}
+	}
+
+	return i, music
+}
+
+// skipSimultaneous returns the index after the << >> block starting at
+// {start} in {tokens}.
+func skipSimultaneous(tokens []lyToken, start int) int {
+	depth := 0
+	for i := start; i < len(tokens); i++ {
+		switch {
+		case isWord(tokens[i], "<<"):
+			depth++
+		case isWord(tokens[i], ">>"):
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+
+	return len(tokens)
+}
+
+// isWord reports whether {t} is the plain word {text}.
+func isWord(t lyToken, text string) bool {
+	return t.kind == 'w' && t.text == text
+}
+
+// addMidi returns {src} with an empty \midi block added at the end of each
+// score that doesn't have one, so that Lilypond writes a MIDI file for it.
+// Music at the top level is put in a score of its own first. The block is
+// added on the line of the closing brace, so line numbers don't change. The
+// second return value is false if there is no music to add it to.
+func addMidi(src []byte) ([]byte, bool) {
+	src, scores := allScores(src)
+	if len(scores) == 0 {
+		return src, false
+	}
+
+	clean := stripComments(src)
+	var out bytes.Buffer
+	pos := 0
+	for _, s := range scores {
+		if midiRx.Match(clean[s.open:s.close]) {
+			continue
+		}
+		out.Write(src[pos:s.close])
+		out.WriteString(` \midi { } `)
+		pos = s.close
+	}
+	out.Write(src[pos:])
+
+	return out.Bytes(), true
+}
+
+// parseTranspose parses a transposition given as {from}:{to}, e.g. "c:bes",
+// where both are Lilypond pitches in Dutch note names.
+func parseTranspose(value string) (string, string, error) {
+	from, to, ok := strings.Cut(value, ":")
+	from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+	if !ok || !pitchRx.MatchString(from) || !pitchRx.MatchString(to) {
+		return "", "", fmt.Errorf("invalid transposition %s, use from:to like c:bes", value)
+	}
+
+	return from, to, nil
+}
+
+// addTranspose returns {src} with the music of each score transposed from
+// the pitch {from} to {to}, including music at the top level. The music is
+// the first expression in a score, so \transpose is added right after the
+// opening brace, which also keeps line numbers unchanged. The second return
+// value is false if there is no music to transpose.
+func addTranspose(src []byte, from, to string) ([]byte, bool) {
+	src, scores := allScores(src)
+	if len(scores) == 0 {
+		return src, false
+	}
+
+	var out bytes.Buffer
+	pos := 0
+	for _, s := range scores {
+		out.Write(src[pos : s.open+1])
+		fmt.Fprintf(&out, " \\transpose %s %s ", from, to)
+		pos = s.open + 1
+	}
+	out.Write(src[pos:])
+
+	return out.Bytes(), true
+}
+
+// addStaffSize returns {src} with the staff size of each score set to
+// {size}, including music at the top level. The size is set in the \layout
+// block of the score, and a \layout block is added if there is none, since
+// a second one would typeset the score twice. The second return value is
+// false if there is no music.
+func addStaffSize(src []byte, size int) ([]byte, bool) {
+	src, scores := allScores(src)
+	if len(scores) == 0 {
+		return src, false
+	}
+
+	clean := stripComments(src)
+	var out bytes.Buffer
+	pos := 0
+	for _, s := range scores {
+		setSize := fmt.Sprintf(" #(layout-set-staff-size %d) ", size)
+		at := s.close
+		if loc := layoutRx.FindIndex(clean[s.open:s.close]); loc != nil && !inString(clean, s.open+loc[0]) {
+			at = s.open + loc[1]
+		} else {
+			setSize = ` \layout {` + setSize + `} `
+		}
+		out.Write(src[pos:at])
+		out.WriteString(setSize)
+		pos = at
+	}
+	out.Write(src[pos:])
+
+	return out.Bytes(), true
+}
+
+// tunePart is a part of a tune with several parts, and the variable that
+// holds its music.
+type tunePart struct {
+	name, variable string
+}
+
+// findParts returns the parts of the tune {src}. They are either declared
+// in a comment like "%% parts: melody, seconds", where each name is also the
+// variable holding the part, or found by convention as all variables named
+// like "secondsPart".
+func findParts(src []byte) []tunePart {
+	parts := []tunePart{}
+	if m := partsRx.FindSubmatch(src); m != nil {
+		for _, name := range strings.FieldsFunc(string(m[1]), func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+			parts = append(parts, tunePart{name, name})
+		}
+		return parts
+	}
+	for _, m := range partVarRx.FindAllSubmatch(stripComments(src), -1) {
+		parts = append(parts, tunePart{string(m[1]), string(m[1]) + "Part"})
+	}
+
+	return parts
+}
+
+// extractPart returns {src} with the part {name} as its only music. The
+// music of the first score is replaced by the part's variable, keeping the
+// contexts it is put in, like \new Staff \with { ... }, and the layout,
+// header and other settings of the score. All other scores are blanked out.
+// Music at the top level counts as a score. Newlines are kept, so line
+// numbers don't change. The second return value is false if there is no
+// such part or no score to put it in.
+func extractPart(src []byte, name string) ([]byte, bool) {
+	parts := findParts(src)
+	i := slices.IndexFunc(parts, func(p tunePart) bool { return p.name == name })
+	out, scores := allScores(src)
+	if i < 0 || len(scores) == 0 {
+		return src, false
+	}
+	start, end, ok := scoreMusic(out, scores[0])
+	if !ok {
+		return src, false
+	}
+
+	blank := func(from, to int) {
+		for j := from; j < to; j++ {
+			if out[j] != '\n' {
+				out[j] = ' '
+			}
+		}
+	}
+	blank(start, end)
+	for _, s := range scores[1:] {
+		blank(s.start, s.close+1)
+	}
+	music := fmt.Sprintf("\\%s", parts[i].variable)
+
+	return slices.Concat(out[:start], []byte(music), out[start:]), true
+}
+
+// scoreMusic returns the start and end of the music of the score {s} in
+// {src}, which is the first expression in it. Commands before the music,
+// like \new Staff, are left out. The last return value is false if the
+// score is empty.
+func scoreMusic(src []byte, s block) (int, int, bool) {
+	tokens := tokenize(src)
+	k := slices.IndexFunc(tokens, func(t lyToken) bool { return t.start > s.open })
+	if k < 0 || tokens[k].start >= s.close {
+		return 0, 0, false
+	}
+	end, _ := skipMusic(tokens, k)
+	if end == k {
+		return 0, 0, false
+	}
+
+	music := k
+	for i := k; i < end; i++ {
+		if tokens[i].kind == '{' && i > k && tokens[i-1].text == `\with` {
+			i = skipValue(tokens, i) - 1
+			continue
+		}
+		if tokens[i].kind == '{' || isWord(tokens[i], "<<") {
+			music = i
+			break
+		}
+	}
+
+	return tokens[music].start, tokens[end-1].end, true
+}
+
+// region is a part of a tune between %%% START and %%% END markers. A SKIP
+// region is left out when building for any of its {names}, and an ONLY
+// region is left out unless building for one of them. {start} and {end}
+// are the indexes of the marker lines.
+type region struct {
+	kind       string
+	names      []string
+	start, end int
+}
+
+// active reports whether the region is part of the document built for
+// {modes}.
+func (r region) active(modes []string) bool {
+	named := slices.ContainsFunc(r.names, func(n string) bool { return slices.Contains(modes, n) })
+	if r.kind == "ONLY" {
+		return named
+	}
+	return !named
+}
+
+// parseRegions returns all regions in {src}, outer regions before the ones
+// nested in them. A SKIP region without names is skipped in previews, as
+// that was all an unnamed region used to do. Markers that don't match up
+// are errors.
+func parseRegions(src []byte) ([]region, error) {
+	regions := []region{}
+	open := []region{}
+	for i, line := range bytes.Split(src, []byte("\n")) {
+		m := regionRx.FindSubmatch(line)
+		if m == nil {
+			continue
+		}
+		kind := string(m[2])
+		names := strings.FieldsFunc(string(m[3]), func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+
+		if string(m[1]) == "START" {
+			if len(names) == 0 && kind == "SKIP" {
+				names = []string{"preview"}
+			}
+			if len(names) == 0 {
+				return nil, fmt.Errorf("line %d: %%%%%% START ONLY needs a name", i+1)
+			}
+			open = append(open, region{kind, names, i, -1})
+			continue
+		}
+
+		if len(open) == 0 {
+			return nil, fmt.Errorf("line %d: %%%%%% END %s without %%%%%% START", i+1, kind)
+		}
+		r := open[len(open)-1]
+		if r.kind != kind || (len(names) > 0 && !slices.Equal(names, r.names)) {
+			return nil, fmt.Errorf("line %d: %%%%%% END %s doesn't match %%%%%% START %s on line %d",
+				i+1, strings.TrimSpace(kind+" "+strings.Join(names, " ")), r.kind+" "+strings.Join(r.names, " "), r.start+1)
+		}
+		open = open[:len(open)-1]
+		r.end = i
+		regions = append(regions, r)
+	}
+	if len(open) > 0 {
+		r := open[len(open)-1]
+		return nil, fmt.Errorf("line %d: %%%%%% START %s is never closed", r.start+1, r.kind)
+	}
+	slices.SortFunc(regions, func(a, b region) int { return a.start - b.start })
+
+	return regions, nil
+}
+
+// regionNames returns the names used by all regions in {src}.
+func regionNames(src []byte) ([]string, error) {
+	regions, err := parseRegions(src)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, r := range regions {
+		names = append(names, r.names...)
+	}
+	slices.Sort(names)
+
+	return slices.Compact(names), nil
+}
+
+// selectLines reports for each line in {src} whether it is part of the
+// document built for {modes}. A line is part of it if all regions it is in
+// are active, and the marker lines belong to the region they start or end.
+// With no modes at all, every line is selected.
+func selectLines(src []byte, modes []string) ([]bool, error) {
+	regions, err := parseRegions(src)
+	if err != nil {
+		return nil, err
+	}
+	selected := make([]bool, bytes.Count(src, []byte("\n"))+1)
+	for i := range selected {
+		selected[i] = true
+	}
+	if modes == nil {
+		return selected, nil
+	}
+	for _, r := range regions {
+		if r.active(modes) {
+			continue
+		}
+		for i := r.start; i <= r.end; i++ {
+			selected[i] = false
+		}
+	}
+
+	return selected, nil
+}
@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_addMidi(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		want   string
+		wantOk bool
+	}{
+		{"no_music", `\header { title = "A" } \markup "B"`, `\header { title = "A" } \markup "B"`, false},
+		{"top_level_music", "{ c d e }", "\\score { { c d e }  \\midi { } }", true},
+		{"single_score", "\\score {\n  { c d e }\n}\n", "\\score {\n  { c d e }\n \\midi { } }\n", true},
+		{"has_midi", `\score { a \midi { } } \score { b }`, `\score { a \midi { } } \score { b  \midi { } }`, true},
+		{"two_scores", `\score { a } \score { b }`, `\score { a  \midi { } } \score { b  \midi { } }`, true},
+		{"commented_score", "% \\score { a }\n\\score { b }", "% \\score { a }\n\\score { b  \\midi { } }", true},
+		{"brace_in_string", `\score { \markup "}" a }`, `\score { \markup "}" a  \midi { } }`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := addMidi([]byte(tt.src))
+			if string(got) != tt.want || ok != tt.wantOk {
+				t.Errorf("addMidi() = %q, %t, want %q, %t", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_findTopLevelMusic(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{"none", "\\version \"2.24.0\"\n\\header { title = \"A\" }\n\\score { a }", []string{}},
+		{"braces", "\\header { title = \"A\" }\n{ c d e }", []string{"{ c d e }"}},
+		{"relative", "melody = \\relative c' { c d }\n\\relative c'' { \\melody }\n\\markup \"x\"", []string{"\\relative c'' { \\melody }"}},
+		{"simultaneous", "<< \\new Staff { a } \\new Staff { b } >>", []string{"<< \\new Staff { a } \\new Staff { b } >>"}},
+		{"with_and_lyrics", `\new Staff = "s" \with { instrumentName = "A" } { a } \addlyrics { la }`, []string{`\new Staff = "s" \with { instrumentName = "A" } { a } \addlyrics { la }`}},
+		{"two", "{ a }\n% { b }\n{ c }", []string{"{ a }", "{ c }"}},
+		{"setting", "\\pointAndClickOff\n\\header { title = \"A\" }", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, m := range findTopLevelMusic([]byte(tt.src)) {
+				got = append(got, tt.src[m[0]:m[1]])
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findTopLevelMusic() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_addStaffSize(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		want   string
+		wantOk bool
+	}{
+		{"no_music", `\markup "A"`, `\markup "A"`, false},
+		{"top_level_music", "{ c d e }", `\score { { c d e }  \layout { #(layout-set-staff-size 18) } }`, true},
+		{"no_layout", `\score { a }`, `\score { a  \layout { #(layout-set-staff-size 18) } }`, true},
+		{"layout", `\score { a \layout { indent = 0 } }`, `\score { a \layout { #(layout-set-staff-size 18)  indent = 0 } }`, true},
+		{"commented_layout", "\\score { a % \\layout { }\n}", "\\score { a % \\layout { }\n \\layout { #(layout-set-staff-size 18) } }", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := addStaffSize([]byte(tt.src), 18)
+			if string(got) != tt.want || ok != tt.wantOk {
+				t.Errorf("addStaffSize() = %q, %t, want %q, %t", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_addTranspose(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		want   string
+		wantOk bool
+	}{
+		{"no_music", `\markup "A"`, `\markup "A"`, false},
+		{"top_level_music", "{ c d e }", "\\score { \\transpose c bes  { c d e } }", true},
+		{"single_score", "\\score {\n  { c d e }\n}\n", "\\score { \\transpose c bes \n  { c d e }\n}\n", true},
+		{"two_scores", `\score { a } \score { b }`, `\score { \transpose c bes  a } \score { \transpose c bes  b }`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := addTranspose([]byte(tt.src), "c", "bes")
+			if string(got) != tt.want || ok != tt.wantOk {
+				t.Errorf("addTranspose() = %q, %t, want %q, %t", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_parseTranspose(t *testing.T) {
+	tests := []struct {
+		value    string
+		from, to string
+		wantErr  bool
+	}{
+		{"c:bes", "c", "bes", false},
+		{"d : fis'", "d", "fis'", false},
+		{"c", "", "", true},
+		{"c:h", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			from, to, err := parseTranspose(tt.value)
+			if from != tt.from || to != tt.to || (err != nil) != tt.wantErr {
+				t.Errorf("parseTranspose() = %q, %q, %v, want %q, %q", from, to, err, tt.from, tt.to)
+			}
+		})
+	}
+}
+
+func Test_findParts(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []tunePart
+	}{
+		{"no_parts", "melody = { c d e }", []tunePart{}},
+		{"declared", "%% parts: melody, seconds\nmelody = { c }", []tunePart{{"melody", "melody"}, {"seconds", "seconds"}}},
+		{"convention", "melodyPart = { c }\ndrumsPart = \\drummode { bd }", []tunePart{{"melody", "melodyPart"}, {"drums", "drumsPart"}}},
+		{"commented_convention", "% melodyPart = { c }\ndrumsPart = { c }", []tunePart{{"drums", "drumsPart"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findParts([]byte(tt.src)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findParts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_extractPart(t *testing.T) {
+	parts := "melodyPart = { c }\nsecondsPart = { e }\n"
+	tests := []struct {
+		name   string
+		part   string
+		src    string
+		want   string
+		wantOk bool
+	}{
+		{"simultaneous", "seconds", "\\score {\n  << \\melodyPart\n  \\secondsPart >>\n}\n", "\\score {\n  \\secondsPart              \n                 \n}\n", true},
+		{"settings", "melody", `\score { \new Staff \with { instrumentName = "A" } << \melodyPart \secondsPart >> \header { piece = "A" } \layout { indent = 0 } }`,
+			`\score { \new Staff \with { instrumentName = "A" } \melodyPart` + strings.Repeat(" ", 30) + ` \header { piece = "A" } \layout { indent = 0 } }`, true},
+		{"other_scores", "melody", `\score { \secondsPart \midi { } } \score { \secondsPart }`, `\score { \melodyPart` + strings.Repeat(" ", 13) + `\midi { } }` + strings.Repeat(" ", 24), true},
+		{"top_level_music", "melody", `{ \melodyPart }`, `\score { \melodyPart                }`, true},
+		{"missing_part", "drums", `\score { \melodyPart }`, `\score { \melodyPart }`, false},
+		{"no_music", "melody", `\markup "A"`, `\markup "A"`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := extractPart([]byte(parts+tt.src), tt.part)
+			if string(got) != parts+tt.want || ok != tt.wantOk {
+				t.Errorf("extractPart() = %q, %t, want %q, %t", got, ok, parts+tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_selectLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		modes   []string
+		want    []bool
+		wantErr bool
+	}{
+		{"unnamed_skip_in_preview", "a\n%%% START SKIP\nb\n%%% END SKIP\nc", []string{"preview"}, []bool{true, false, false, false, true}, false},
+		{"unnamed_skip_in_pdf", "a\n%%% START SKIP\nb\n%%% END SKIP\nc", []string{"pdf"}, []bool{true, true, true, true, true}, false},
+		{"only_pdf_in_svg", "%%% START ONLY pdf\na\n%%% END ONLY pdf", []string{"svg"}, []bool{false, false, false}, false},
+		{"only_list", "%%% START ONLY pdf, svg\na\n%%% END ONLY", []string{"svg"}, []bool{true, true, true}, false},
+		{"nested", "%%% START ONLY pdf\na\n  %%% START SKIP post\nb\n  %%% END SKIP post\n%%% END ONLY pdf", []string{"pdf", "post"}, []bool{true, true, false, false, false, true}, false},
+		{"no_modes", "%%% START ONLY pdf\na\n%%% END ONLY", nil, []bool{true, true, true}, false},
+		{"unclosed", "%%% START SKIP preview\na", nil, nil, true},
+		{"end_without_start", "a\n%%% END SKIP", nil, nil, true},
+		{"mismatched_kind", "%%% START SKIP pdf\n%%% END ONLY pdf", nil, nil, true},
+		{"mismatched_name", "%%% START SKIP pdf\n%%% END SKIP svg", nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectLines([]byte(tt.src), tt.modes)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectLines() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selectLines() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
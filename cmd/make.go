@@ -2,12 +2,21 @@ package cmd
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/urfave/cli/v3"
 )
@@ -15,7 +24,39 @@ import (
 var makeCmd = &cli.Command{
 	Name:  "make",
 	Usage: "Run Lilypond on music file(s)",
-	Flags: []cli.Flag{
+	Flags: makeFlags(),
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		config := GetConfig()
+		config.FontInclude = cmd.String("font-include")
+
+		if err := checkReportFormat(cmd); err != nil {
+			return err
+		}
+		files, err := expandGlobs(cmd.Args().Slice())
+		if err != nil {
+			return err
+		}
+
+		started := time.Now()
+		cache := loadBuildCache()
+		results := buildTunes(ctx, cmd, files, cache)
+		if err := cache.save(); err != nil {
+			printWarning("failed to save build cache: %w", err)
+		}
+		if err := writeReport(cmd, results, started); err != nil {
+			return printAndReturnError("failed to write report: %w", err)
+		}
+		if failed := printSummary(progressWriter(cmd), results, len(files)); failed > 0 {
+			return printAndReturnError("%d of %d tunes failed", failed, len(files))
+		}
+		return nil
+	},
+}
+
+// makeFlags returns the flags used by the make command. Commands that run
+// the same pipeline, like watch, use them as well.
+func makeFlags() []cli.Flag {
+	return []cli.Flag{
 		&cli.IntFlag{
 			Name:    "resolution",
 			Aliases: []string{"r"},
@@ -44,7 +85,23 @@ var makeCmd = &cli.Command{
 			Name:    "type",
 			Aliases: []string{"t"},
 			Value:   "pdf",
-			Usage:   "save output as {type}",
+			Usage:   "save output as {type} (pdf, png, svg or midi, or a comma separated list of them)",
+		},
+		&cli.StringFlag{
+			Name:  "transpose",
+			Usage: "transpose the music from one pitch to another given as {from:to}, e.g. c:bes",
+		},
+		&cli.StringSliceFlag{
+			Name:  "part",
+			Usage: "only create the part {name} of a tune with several parts",
+		},
+		&cli.BoolFlag{
+			Name:  "all-parts",
+			Usage: "create every part of a tune with several parts as well as the full score",
+		},
+		&cli.StringFlag{
+			Name:  "audio",
+			Usage: "render MIDI output to an audio file in {format} (wav, ogg or mp3)",
 		},
 		&cli.BoolFlag{
 			Name:    "landscape",
@@ -60,6 +117,11 @@ var makeCmd = &cli.Command{
 			Name:  "post",
 			Usage: "generate a png for posting to social media",
 		},
+		&cli.StringFlag{
+			Name:  "post-preset",
+			Value: "default",
+			Usage: "use the post preset {name} for --post, implies --post",
+		},
 		&cli.BoolFlag{
 			Name:  "root",
 			Usage: "save result in project root",
@@ -68,6 +130,15 @@ var makeCmd = &cli.Command{
 			Name:  "crop",
 			Usage: "crop page to minimal size",
 		},
+		&cli.BoolFlag{
+			Name:  "stitch",
+			Usage: "stitch the pages of multi-page PNG output into a single image",
+		},
+		&cli.IntFlag{
+			Name:  "crop-border",
+			Value: 12,
+			Usage: "leave a border of {pixels} around cropped PNG files",
+		},
 		&cli.BoolFlag{
 			Name:  "point-and-click",
 			Usage: "turn on point-and-click",
@@ -80,33 +151,29 @@ var makeCmd = &cli.Command{
 			Name:  "font-include",
 			Usage: "include font configuration file",
 		},
-	},
-
-	Action: func(ctx context.Context, cmd *cli.Command) error {
-		config := GetConfig()
-		config.FontInclude = cmd.String("font-include")
-
-		args := cmd.Args().Slice()
-		maker := &maker{cmd}
-		for _, arg := range args {
-			files := []string{arg}
-			if strings.Contains(arg, "*") {
-				var err error
-				files, err = filepath.Glob(pathFromRoot(arg))
-				if err != nil {
-					return printAndReturnError("failed to expand glob pattern %s: %w", arg, err)
-				}
-				if len(files) == 0 {
-					printWarning("no files matched pattern %s", arg)
-					continue
-				}
-			}
-			for _, f := range files {
-				maker.run(getSourcePath(f))
-			}
-		}
-		return nil
-	},
+		&cli.IntFlag{
+			Name:    "jobs",
+			Aliases: []string{"j"},
+			Value:   runtime.NumCPU(),
+			Usage:   "number of files to process in parallel",
+		},
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "build all files even if their output is up to date",
+		},
+		&cli.BoolFlag{
+			Name:  "fail-fast",
+			Usage: "stop at the first file that fails",
+		},
+		&cli.StringFlag{
+			Name:  "report",
+			Usage: "print a report of the run in {format} (json) to stdout",
+		},
+		&cli.StringFlag{
+			Name:  "report-file",
+			Usage: "write a JSON report of the run to {file}",
+		},
+	}
 }
 
 // Default template if none is provided in config
@@ -137,157 +204,745 @@ const makeHeaderTemplate = `%% Generated from {{.sourceFile}} by domusic
 %% The tune to generate.
 `
 
+// makeResult is the outcome of running make on a single tune.
+type makeResult struct {
+	source      string
+	skipped     bool
+	err         error
+	outputs     []string
+	exitCode    int
+	duration    time.Duration
+	diagnostics []diagnostic
+	logFile     string
+	tempDir     string
+}
+
+// buildTunes runs make on each of {files} with the flags given in {cmd}. It
+// returns the results of all tunes that were processed, which is all of
+// them unless --fail-fast stopped the run early.
+func buildTunes(ctx context.Context, cmd *cli.Command, files []string, cache *buildCache) []makeResult {
+	results := make([]makeResult, len(files))
+	runner := newLilypondRunner()
+	installed := installedVersion(ctx, runner)
+	w := progressWriter(cmd)
+	runJobs(len(files), cmd.Int("jobs"), cmd.Bool("fail-fast"), w, func(i int, out io.Writer) error {
+		m := &maker{ctx: ctx, cmd: cmd, out: out, cache: cache, runner: runner, lilyVersion: installed}
+		src := getSourcePath(files[i])
+		start := time.Now()
+		err := m.run(src)
+		results[i] = makeResult{
+			source:      src,
+			skipped:     m.skipped,
+			err:         err,
+			outputs:     m.outputs,
+			exitCode:    m.exitCode,
+			duration:    time.Since(start),
+			diagnostics: m.diagnostics,
+			logFile:     m.logFile,
+			tempDir:     m.tempDir,
+		}
+		return err
+	})
+
+	// Logs are opened one at a time after all jobs are done, so parallel
+	// jobs don't start several editors at once
+	for _, r := range results {
+		if r.logFile != "" {
+			fmt.Fprintln(w, "Opening log file", r.logFile)
+			e, ea, _ := getEditor()
+			c := exec.Command(e, append(ea, r.logFile)...)
+			c.Run()
+		}
+		if r.tempDir != "" {
+			os.RemoveAll(r.tempDir)
+		}
+	}
+
+	return slices.DeleteFunc(results, func(r makeResult) bool { return r.source == "" })
+}
+
+// printSummary prints how many of the {total} tunes were built, skipped and
+// failed to {w}, and lists the failed ones. It returns the number of
+// failures.
+func printSummary(w io.Writer, results []makeResult, total int) int {
+	built, skipped := 0, 0
+	failed := []string{}
+	for _, r := range results {
+		switch {
+		case r.err != nil:
+			failed = append(failed, fmt.Sprintf("%s: %v", makeRel(r.source), r.err))
+		case r.skipped:
+			skipped++
+		default:
+			built++
+		}
+	}
+
+	fmt.Fprintf(w, "%d built, %d skipped, %d failed", built, skipped, len(failed))
+	if notRun := total - len(results); notRun > 0 {
+		fmt.Fprintf(w, ", %d not processed", notRun)
+	}
+	fmt.Fprintln(w)
+	for _, f := range failed {
+		fmt.Fprintln(w, "  failed:", f)
+	}
+
+	return len(failed)
+}
+
+// runJobs calls fn for the indexes 0 to {n}-1, running at most {jobs} calls
+// at the same time. With more than one job, everything fn writes to its
+// writer is buffered and written to {w} in one piece when the call is done,
+// so progress lines from different files never interleave. If {failFast}
+// is set, no new calls are started once a call has returned an error.
+func runJobs(n, jobs int, failFast bool, w io.Writer, fn func(i int, out io.Writer) error) {
+	var stop atomic.Bool
+	call := func(i int, out io.Writer) {
+		if err := fn(i, out); err != nil && failFast {
+			stop.Store(true)
+		}
+	}
+
+	if jobs <= 1 {
+		for i := 0; i < n && !stop.Load(); i++ {
+			call(i, w)
+		}
+		return
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan int)
+	for range min(jobs, n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				var buf bytes.Buffer
+				call(i, &buf)
+				mu.Lock()
+				w.Write(buf.Bytes())
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < n && !stop.Load(); i++ {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+}
+
 type maker struct {
-	cmd *cli.Command
+	ctx    context.Context
+	cmd    *cli.Command
+	out    io.Writer
+	cache  *buildCache
+	runner lilypondRunner
+	// Version number of the installed Lilypond, empty if unknown
+	lilyVersion string
+	// Set if the source is a complete document, like a collection, that is
+	// used as is instead of being put into the make template
+	document bool
+
+	// Temporary directory where the current tune is built
+	workDir string
+	// Pitches to transpose the music from and to, empty if not transposing
+	transposeFrom, transposeTo string
+	// Part of the tune being built, empty for the full score
+	part string
+	// Preset for social media images, nil if not making one
+	post *PostPreset
+	// Set if the current tune was up to date and not built
+	skipped bool
+	// Log of a failed build that couldn't be parsed, to be opened later
+	logFile string
+	// Build directory kept for logFile, to be removed once it is shown
+	tempDir string
+	// The files produced for the current tune
+	outputs []string
+	// Exit status of the last Lilypond run, -1 if it couldn't be started
+	exitCode int
+	// All diagnostics from the Lilypond runs for the current tune
+	diagnostics []diagnostic
 }
 
 func (m *maker) run(src string) error {
 	var err error
-	fmt.Println("Processing file", src)
+	fmt.Fprintln(m.out, "Processing file", src)
+	if data, err := os.ReadFile(src); err == nil {
+		if d := checkVersion(src, data, m.lilyVersion); d != nil {
+			m.report([]diagnostic{*d})
+		}
+	}
 
 	// Handle post flag overrides
-	outputType := m.cmd.String("type")
+	types, err := outputTypes(m.cmd)
+	if err != nil {
+		return err
+	}
 	resolution := m.cmd.Int("resolution")
-	if m.cmd.Bool("post") {
-		outputType = "png"
-		if resolution == 144 { // default resolution
-			resolution = 84
+	if m.cmd.Bool("post") || m.cmd.IsSet("post-preset") {
+		if m.post, err = getPostPreset(m.cmd.String("post-preset")); err != nil {
+			return err
+		}
+		types = []string{"png"}
+		if !m.cmd.IsSet("resolution") {
+			resolution = cmp.Or(m.post.Resolution, defaultPostPreset.Resolution)
 		}
 	}
 
-	if outputType == "pdf" {
-		fmt.Println("  * Creating preview file")
-		err = m.preview(src, resolution)
-		if err == nil {
-			fmt.Println("  * Creating PDF file")
-			err = m.pdf(src)
+	audio := m.cmd.String("audio")
+	if audio != "" && !slices.Contains(types, "midi") {
+		return fmt.Errorf("--audio needs --type midi")
+	}
+	if audio != "" && !slices.Contains([]string{"wav", "ogg", "mp3"}, audio) {
+		return fmt.Errorf("unknown audio format %s", audio)
+	}
+	if t := m.cmd.String("transpose"); t != "" {
+		if m.transposeFrom, m.transposeTo, err = parseTranspose(t); err != nil {
+			return err
+		}
+	}
+
+	parts := []string{""}
+	if m.cmd.Bool("all-parts") || len(m.cmd.StringSlice("part")) > 0 {
+		if parts, err = m.selectParts(src); err != nil {
+			return err
+		}
+	}
+
+	// The tune is skipped only if nothing at all had to be built
+	skipped := true
+	for _, part := range parts {
+		m.part = part
+		if part != "" {
+			fmt.Fprintln(m.out, "  * Part", part)
+		}
+		if err := m.build(src, types, resolution); err != nil {
+			return err
+		}
+		skipped = skipped && m.skipped
+	}
+	m.skipped = skipped
+
+	return nil
+}
+
+// build creates the output {types} for {src}, or for the current part of
+// it, unless the output is already up to date.
+func (m *maker) build(src string, types []string, resolution int) error {
+	var err error
+	audio := m.cmd.String("audio")
+	name := m.outputName(src)
+	// Outputs from earlier parts of the same tune are already in the list
+	first := len(m.outputs)
+	m.skipped = false
+
+	source, err := os.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("failed to read source file %s: %w", src, err)
+	}
+	named, err := regionNames(source)
+	if err != nil {
+		return fmt.Errorf("%s: %w", src, err)
+	}
+	passes := m.passes(types, resolution, named)
+
+	// Only output moved to the output directory is tracked in the cache
+	hash := ""
+	if m.cache != nil && m.cacheable() {
+		hash, err = m.buildHash(src, types, resolution, passes)
+		if err == nil && !m.cmd.Bool("force") {
+			if outputs, ok := m.cache.upToDate(name, hash); ok {
+				fmt.Fprintln(m.out, "  * Up to date, skipping")
+				m.skipped = true
+				m.outputs = append(m.outputs, outputs...)
+				return nil
+			}
+		}
+	}
+
+	m.workDir, err = os.MkdirTemp("", "domusic-")
+	if err != nil {
+		return fmt.Errorf("failed to create build directory: %w", err)
+	}
+	workDir, keepTemp := m.workDir, false
+	defer func() {
+		if !keepTemp {
+			os.RemoveAll(workDir)
+		}
+	}()
+
+	for _, p := range passes {
+		fmt.Fprintf(m.out, "  * Creating %s\n", p.desc)
+		if err = m.runLilypond(src, p.args, p.modes); err != nil {
+			break
 		}
-	} else {
-		fmt.Println("  * Creating PNG file")
-		err = m.png(src, resolution)
 	}
 
-	templateFile := getTemplatePath(src)
+	templateFile := m.templateFile(src)
 
 	if err != nil {
-		fmt.Println("  * Opening log file")
-		e, ea, _ := getEditor()
-		logFile := strings.TrimSuffix(templateFile, ".ly") + ".log"
-		c := exec.Command(e, append(ea, logFile)...)
-		c.Run()
+		if m.document {
+			// The document is removed after the build, so there is nothing
+			// to keep. Show the log if it couldn't be parsed.
+			if !slices.ContainsFunc(m.diagnostics, diagnostic.isError) {
+				log, _ := os.ReadFile(strings.TrimSuffix(templateFile, ".ly") + ".log")
+				m.out.Write(log)
+			}
+			return err
+		}
+		// Keep the generated files of failed builds for inspection if asked
+		// for
+		keepDir := m.workDir
+		if m.cmd.Bool("keep") {
+			keepDir = m.keepWorkDir(src)
+		}
+		if slices.ContainsFunc(m.diagnostics, diagnostic.isError) {
+			return err
+		}
+		// Nothing useful could be parsed, so the whole log is shown instead
+		// once all tunes are done. The build directory is removed after that.
+		m.logFile = filepath.Join(keepDir, strings.TrimSuffix(filepath.Base(templateFile), ".ly")+".log")
+		if !m.cmd.Bool("keep") {
+			keepTemp = true
+			m.tempDir = workDir
+		}
 		return err
 	}
 
 	if m.cmd.Bool("keep") {
-		return nil
+		m.keepWorkDir(src)
 	}
 
-	fmt.Println("  * Cleaning up")
-	if m.cmd.Bool("crop") || m.cmd.Bool("post") {
-		m.crop(templateFile)
+	if slices.Contains(types, "png") {
+		if err := m.processPNG(templateFile); err != nil {
+			return err
+		}
 	}
-	cleanup(templateFile)
-	if !m.cmd.Bool("root") {
-		moveFiles(templateFile, src)
+	if m.cmd.Bool("root") {
+		m.outputs = append(m.outputs, moveToRoot(templateFile)...)
+	} else {
+		for _, t := range types {
+			m.removeOldOutputs(name, t)
+			var moved []string
+			switch t {
+			case "pdf":
+				moved = moveFiles(templateFile, name)
+			case "png":
+				moved = movePngFiles(templateFile, name)
+			case "svg":
+				moved = moveSvgFiles(templateFile, name)
+			case "midi":
+				moved = moveMidiFiles(templateFile, name)
+			}
+			if len(moved) == 0 && t == "midi" {
+				return fmt.Errorf("no MIDI output, the tune has no music")
+			}
+			if len(moved) == 0 {
+				return fmt.Errorf("no %s output from lilypond", t)
+			}
+			m.outputs = append(m.outputs, moved...)
+		}
+		m.cache.addFiles(name, m.outputs[first:])
+	}
+	if audio != "" {
+		fmt.Fprintln(m.out, "  * Rendering audio")
+		for _, midiFile := range slices.Clone(m.outputs[first:]) {
+			if filepath.Ext(midiFile) != ".midi" {
+				continue
+			}
+			audioFile, err := m.renderAudio(midiFile, audio)
+			if err != nil {
+				return err
+			}
+			m.outputs = append(m.outputs, audioFile)
+		}
+	}
+	for _, o := range m.outputs[first:] {
+		fmt.Fprintln(m.out, "  * Created", makeRel(o))
+	}
+	if hash != "" && len(m.outputs) > first {
+		m.cache.set(name, hash, m.outputs[first:])
 	}
 
 	return nil
 }
 
-func (m *maker) preview(src string, resolution int) error {
-	lyArgs := []string{
-		"--png",
-		"-dpreview",
-		"-dno-print-pages",
-		fmt.Sprintf("-dresolution=%d", resolution),
-		"-dpreview-include-book-title",
-		"-dwithout-comment",
+// outputTypes returns the output types asked for with the type flag, or
+// the configured default types if the flag isn't given. Types are given as
+// a comma separated list and duplicates are removed.
+func outputTypes(cmd *cli.Command) ([]string, error) {
+	value := cmd.String("type")
+	if configured := GetConfig().OutputTypes; !cmd.IsSet("type") && len(configured) > 0 {
+		value = strings.Join(configured, ",")
+	}
+
+	types := []string{}
+	for _, t := range strings.Split(value, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		switch {
+		case t == "" || slices.Contains(types, t):
+			continue
+		case !slices.Contains([]string{"pdf", "png", "svg", "midi"}, t):
+			return nil, fmt.Errorf("unknown output type %s", t)
+		}
+		types = append(types, t)
+	}
+	if len(types) == 0 {
+		return nil, fmt.Errorf("no output type given")
 	}
 
-	return m.runLilypond(src, lyArgs, true)
+	return types, nil
 }
 
-func (m *maker) pdf(src string) error {
-	lyArgs := []string{
-		"--pdf",
+// templateFile returns the full path of the generated template for {src}
+// in the build directory.
+func (m *maker) templateFile(src string) string {
+	return filepath.Join(m.workDir, getTemplatePath(m.outputName(src)))
+}
+
+// outputName returns the tune path that the names of all output files for
+// {src} are based on. The part name and the target key of transposed tunes
+// are added to the name, so they don't replace the full score in the
+// original key.
+func (m *maker) outputName(src string) string {
+	name := strings.TrimSuffix(src, filepath.Ext(src))
+	if m.part != "" {
+		name += "-" + m.part
+	}
+	if m.transposeTo != "" {
+		name += "-" + strings.Trim(m.transposeTo, "',")
+	}
+	return name + filepath.Ext(src)
+}
+
+// selectParts returns the parts of {src} to build. With the all-parts flag
+// that is the full score, given as an empty name, and all parts. Otherwise
+// it is the parts given with the part flag, which must all exist.
+func (m *maker) selectParts(src string) ([]string, error) {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source file %s: %w", src, err)
+	}
+	available := []string{}
+	for _, p := range findParts(data) {
+		available = append(available, p.name)
+	}
+	if len(available) == 0 {
+		return nil, fmt.Errorf("%s has no parts", src)
+	}
+
+	if m.cmd.Bool("all-parts") {
+		return append([]string{""}, available...), nil
+	}
+	parts := []string{}
+	for _, p := range m.cmd.StringSlice("part") {
+		if !slices.Contains(available, p) {
+			return nil, fmt.Errorf("%s has no part %s, only %s", src, p, strings.Join(available, ", "))
+		}
+		parts = append(parts, p)
+	}
+
+	return parts, nil
+}
+
+// keepWorkDir copies the build directory to a directory in the music root
+// named after the template, replacing anything that was there before. It
+// returns the path of the copy.
+func (m *maker) keepWorkDir(src string) string {
+	keepDir := pathFromRoot(strings.TrimSuffix(getTemplatePath(m.outputName(src)), ".ly"))
+	fmt.Fprintln(m.out, "  * Keeping generated files in", keepDir)
+	os.RemoveAll(keepDir)
+	if err := copyDir(m.workDir, keepDir); err != nil {
+		printWarning("failed to keep generated files: %w", err)
+	}
+
+	return keepDir
+}
+
+// cacheable reports whether the result of building with the current flags
+// ends up in the output directory, where it can be checked by later runs.
+func (m *maker) cacheable() bool {
+	return !m.cmd.Bool("keep") && !m.cmd.Bool("root")
+}
+
+// buildHash returns a hash of everything that affects the output for
+// {src}: the generated documents, the contents of all files they include,
+// the flags that are not already part of the documents, and the Lilypond
+// that is run.
+func (m *maker) buildHash(src string, types []string, resolution int, passes []lyPass) (string, error) {
+	h := sha256.New()
+	lily := GetConfig().Lilypond
+	fmt.Fprintf(h, "domusic=%s lilypond=%s:%q:%s type=%s resolution=%d crop=%t:%d stitch=%t post=%s:%+v audio=%s transpose=%s:%s part=%s\n",
+		version, lily.Binary, lily.Args, m.lilyVersion, strings.Join(types, ","), resolution, m.cmd.Bool("crop"), m.cmd.Int("crop-border"), m.cmd.Bool("stitch"),
+		m.cmd.String("post-preset"), m.post, m.cmd.String("audio"), m.transposeFrom, m.transposeTo, m.part)
+	for _, p := range passes {
+		doc, _, err := m.renderTemplate(src, p.modes)
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte(doc))
+		for _, dep := range findDependencies(src, []byte(doc)) {
+			data, err := os.ReadFile(dep)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(h, "\n%s\n", dep)
+			h.Write(data)
+		}
 	}
 
-	return m.runLilypond(src, lyArgs, false)
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-func (m *maker) png(src string, resolution int) error {
-	lyArgs := []string{
-		"--png",
-		fmt.Sprintf("-dresolution=%d", resolution),
+// lyPass is a single Lilypond run for a tune. {desc} describes the
+// created files in progress output, and {modes} selects the regions of the
+// tune that are part of the document.
+type lyPass struct {
+	desc  string
+	args  []string
+	modes []string
+}
+
+// passes returns the Lilypond runs needed to create all output {types}.
+// PDF and PNG files are created by the same run, unless the tune has
+// regions {named} after one of them and so needs a separate document for
+// it. MIDI files always get a run of their own, since a score with a \midi
+// block but no \layout block is left out of the printed output. With the
+// post flag, all runs also select the post regions.
+func (m *maker) passes(types []string, resolution int, named []string) []lyPass {
+	var extra []string
+	if m.post != nil {
+		extra = []string{"post"}
+	}
+	passes := []lyPass{}
+	add := func(desc string, args []string, modes ...string) {
+		passes = append(passes, lyPass{desc, args, append(modes, extra...)})
+	}
+
+	if slices.Contains(types, "pdf") {
+		add("preview file", []string{
+			"--png",
+			"-dpreview",
+			"-dno-print-pages",
+			fmt.Sprintf("-dresolution=%d", resolution),
+			"-dpreview-include-book-title",
+			"-dwithout-comment",
+		}, "preview")
+	}
+
+	printed := slices.DeleteFunc(slices.Clone(types), func(t string) bool { return t != "pdf" && t != "png" })
+	if len(printed) == 2 && (slices.Contains(named, "pdf") || slices.Contains(named, "png")) {
+		add("PDF file", []string{"--pdf"}, "pdf")
+		add("PNG file", []string{"--png", fmt.Sprintf("-dresolution=%d", resolution)}, "png")
+	} else if len(printed) > 0 {
+		args := []string{}
+		for _, t := range printed {
+			args = append(args, "--"+t)
+		}
+		if slices.Contains(printed, "png") {
+			args = append(args, fmt.Sprintf("-dresolution=%d", resolution))
+		}
+		add(strings.ToUpper(strings.Join(printed, " and "))+" file", args, printed...)
+	}
+
+	if slices.Contains(types, "svg") {
+		args := []string{"-dbackend=svg"}
+		if m.cmd.Bool("crop") {
+			args = append(args, "-dcrop")
+		}
+		add("SVG file", args, "svg")
 	}
 
-	return m.runLilypond(src, lyArgs, false)
+	if slices.Contains(types, "midi") {
+		add("MIDI file", []string{"-dno-print-pages"}, "midi")
+	}
+
+	return passes
 }
 
-func (m *maker) runLilypond(src string, args []string, minimal bool) error {
+func (m *maker) runLilypond(src string, args []string, modes []string) error {
 	if src != "" {
-		tp, err := m.makeTemplateFile(src, minimal)
+		tp, lineMap, err := m.makeTemplateFile(src, modes)
 		if err != nil {
 			return err
 		}
 		tpBase := strings.TrimSuffix(tp, ".ly")
+		// The template is built outside the music hierarchy, so includes
+		// must be found relative to the root and the tune
+		if root := pathFromRoot(); root != "" {
+			args = append(args, "-I"+root)
+		}
+		args = append(args, "-I"+filepath.Dir(src))
+		for _, p := range getIncludePaths() {
+			args = append(args, "-I"+p)
+		}
 		args = append(args, "-o"+tpBase, tp)
 
-		c := exec.Command("lilypond", args...)
-		errOut, err := c.CombinedOutput()
+		errOut, err := m.runner.run(m.ctx, m.workDir, args)
+		m.exitCode = exitCode(err)
 		os.WriteFile(tpBase+".log", errOut, 0644)
+		m.report(mapDiagnostics(parseLilypondLog(errOut), tp, src, lineMap))
 		return err
 	}
-	c := exec.Command("lilypond", args...)
+	_, err := m.runner.run(m.ctx, m.workDir, args)
+
+	return err
+}
+
+// processPNG crops the PNG files generated from the template {src},
+// stitches their pages together and prepares them for posting, as asked
+// for by the flags. Images for posting are always stitched.
+func (m *maker) processPNG(src string) error {
+	base := strings.TrimSuffix(src, ".ly")
+	paths := findPages(base, "-page", ".png")
+	if len(paths) == 0 {
+		paths = []string{base + ".png"}
+	}
+
+	if m.cmd.Bool("crop") || m.post != nil {
+		fmt.Fprintln(m.out, "  * Cropping PNG file")
+		for _, p := range paths {
+			if err := m.crop(p); err != nil {
+				return fmt.Errorf("failed to crop PNG file: %w", err)
+			}
+		}
+	}
+	if len(paths) > 1 && (m.cmd.Bool("stitch") || m.post != nil) {
+		fmt.Fprintln(m.out, "  * Stitching PNG pages")
+		if err := stitchPNGs(paths, base+".png"); err != nil {
+			return fmt.Errorf("failed to stitch PNG pages: %w", err)
+		}
+		for _, p := range paths {
+			os.Remove(p)
+		}
+	}
+	if m.post != nil {
+		fmt.Fprintln(m.out, "  * Preparing image for posting")
+		if err := postPNG(base+".png", m.post); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// crop trims the PNG file {path} to its content. ImageMagick is used as a
+// fallback for images that can't be trimmed directly.
+func (m *maker) crop(path string) error {
+	border := m.cmd.Int("crop-border")
+	err := cropPNG(path, border)
+	if err != nil && mogrifyCrop(path, border) == nil {
+		return nil
+	}
 
-	return c.Run()
+	return err
 }
 
-func (m *maker) crop(src string) error {
-	path := strings.TrimSuffix(src, ".ly") + ".png"
-	c := exec.Command("mogrify", "-trim", "-bordercolor", "white", "-border", "12", path)
+// report prints {diags} and saves them for the final result.
+func (m *maker) report(diags []diagnostic) {
+	for _, d := range diags {
+		fmt.Fprintln(m.out, d)
+	}
+	m.diagnostics = append(m.diagnostics, diags...)
+}
+
+func (m *maker) makeTemplateFile(sourceFile string, modes []string) (string, []int, error) {
+	template, lineMap, err := m.renderTemplate(sourceFile, modes)
+	if err != nil {
+		return "", nil, err
+	}
+
+	templatePath := m.templateFile(sourceFile)
+	if err := os.WriteFile(templatePath, []byte(template), 0644); err != nil {
+		return "", nil, fmt.Errorf("failed to write template: %w", err)
+	}
 
-	return c.Run()
+	return templatePath, lineMap, nil
 }
 
-func (m *maker) makeTemplateFile(sourceFile string, minimal bool) (string, error) {
-	format := m.cmd.String("format")
+// renderTemplate returns the complete Lilypond document for {sourceFile},
+// i.e. the expanded make template followed by the tune itself. Only the
+// regions of the tune that are active for {modes} are included, and a
+// \midi block is added if the modes include midi. With no modes the whole
+// tune is included, which covers everything it may depend on. Options
+// set at the top of the tune are used for flags not given on the command
+// line. It also returns the line in the tune that each line of the document
+// comes from, with 0 for the lines of the make template. A source that is a
+// complete document already is returned as is.
+func (m *maker) renderTemplate(sourceFile string, modes []string) (string, []int, error) {
+	source, err := os.ReadFile(sourceFile)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read source file %s: %w", sourceFile, err)
+	}
+	if m.document {
+		lineMap := make([]int, bytes.Count(source, []byte("\n"))+1)
+		for i := range lineMap {
+			lineMap[i] = i + 1
+		}
+		return string(source), lineMap, nil
+	}
+	// The template declares the same version as the tune
+	tuneVersion, _ := sourceVersion(source)
+	opts, err := parseTuneOptions(m.cmd, source)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", sourceFile, err)
+	}
+	// Regions are selected before the source is changed below, which keeps
+	// all lines where they are
+	selected, err := selectLines(source, modes)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", sourceFile, err)
+	}
+
+	format := opts.String("format")
 	if format == "default" && strings.Contains(sourceFile, ".book") {
 		format = "book"
 	}
 	data := map[string]any{
 		"sourceFile":    sourceFile,
-		"version":       lowestLilyVersion,
-		"pointAndClick": m.cmd.Bool("point-and-click"),
-		"staffSize":     m.cmd.Int("staff-size"),
-		"paperSize":     m.cmd.String("paper-size"),
-		"landscape":     m.cmd.Bool("landscape"),
+		"version":       cmp.Or(tuneVersion, lowestLilyVersion),
+		"pointAndClick": opts.Bool("point-and-click"),
+		"staffSize":     opts.Int("staff-size"),
+		"paperSize":     opts.String("paper-size"),
+		"landscape":     opts.Bool("landscape"),
 		"headerFormat":  format,
-		"viewSpacing":   m.cmd.Bool("view-spacing"),
-		"removeTagline": m.cmd.Bool("crop") || m.cmd.Bool("post"),
+		"viewSpacing":   opts.Bool("view-spacing"),
+		"removeTagline": m.cmd.Bool("crop") || m.post != nil,
 		"fontInclude":   GetConfig().FontInclude,
 	}
+	footer := ""
+	if m.post != nil {
+		if footer, err = m.post.footer(parseHeader(source)["title"]); err != nil {
+			return "", nil, fmt.Errorf("failed to execute post footer template: %w", err)
+		}
+	}
 	common := GetConfig().Template.Common
 	if common != "" {
 		commonExpanded, err := executeTemplate(common, data)
 		if err != nil {
-			return "", fmt.Errorf("failed to execute common template: %w", err)
+			return "", nil, fmt.Errorf("failed to execute common template: %w", err)
 		}
 		common = commonExpanded
 	}
 
-	source, err := os.ReadFile(sourceFile)
-	if err != nil {
-		return "", fmt.Errorf("failed to read source file %s: %w", sourceFile, err)
+	if m.part != "" {
+		var ok bool
+		if source, ok = extractPart(source, m.part); !ok {
+			return "", nil, fmt.Errorf("can't extract part %s from %s", m.part, sourceFile)
+		}
 	}
-
-	sourceFile = ensureSuffix(noExt(sourceFile), ".ly")
-	templatePath := getTemplatePath(sourceFile)
-	f, err := os.OpenFile(templatePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		return "", err
+	if slices.Contains(modes, "midi") {
+		source, _ = addMidi(source)
+	}
+	if m.transposeTo != "" {
+		var ok bool
+		if source, ok = addTranspose(source, m.transposeFrom, m.transposeTo); !ok {
+			return "", nil, fmt.Errorf("can't transpose %s, it has no music", sourceFile)
+		}
 	}
-	defer f.Close()
 
 	makeTemplate := GetConfig().Template.Make
 	if makeTemplate == "" {
@@ -296,54 +951,155 @@ func (m *maker) makeTemplateFile(sourceFile string, minimal bool) (string, error
 	data["common"] = common
 	template, err := executeTemplate(makeTemplate, data)
 	if err != nil {
-		return "", fmt.Errorf("failed to execute make template: %w", err)
+		return "", nil, fmt.Errorf("failed to execute make template: %w", err)
 	}
 
-	n, err := f.WriteString(template)
-	if err != nil {
-		return "", fmt.Errorf("failed to write template: %w", err)
+	// The tune starts on a line of its own, even if the template doesn't
+	// end with a newline
+	if !strings.HasSuffix(template, "\n") {
+		template += "\n"
+	}
+	var sb strings.Builder
+	sb.WriteString(template)
+	lineMap := make([]int, strings.Count(template, "\n"))
+	for i, line := range bytes.Split(source, []byte("\n")) {
+		if selected[i] {
+			sb.Write(line)
+			sb.WriteByte('\n')
+			lineMap = append(lineMap, i+1)
+		}
 	}
-	if n < len(template) {
-		return "", fmt.Errorf("incomplete write: wrote %d of %d bytes", n, len(template))
+	// The footer goes right below the music, so it is kept close to it
+	// when the image is cropped
+	if footer != "" {
+		fmt.Fprintf(&sb, "\\markup \\fill-line { \"%s\" }\n", escapeLyString(footer))
+		lineMap = append(lineMap, 0)
 	}
 
-	includeLine := true
-	for _, line := range bytes.Split(source, []byte("\n")) {
-		trimmedLine := bytes.TrimLeft(line, " \t")
-		if minimal && bytes.HasPrefix(trimmedLine, []byte("%%% START SKIP")) {
-			includeLine = false
-		}
-		if includeLine {
-			lineStr := string(line) + "\n"
-			n, err = f.WriteString(lineStr)
-			if err != nil {
-				return "", fmt.Errorf("failed to write line: %w", err)
-			}
-			if n < len(lineStr) {
-				return "", fmt.Errorf("incomplete line write: wrote %d of %d bytes", n, len(lineStr))
-			}
-		}
-		if minimal && bytes.HasPrefix(trimmedLine, []byte("%%% END SKIP")) {
-			includeLine = true
+	return sb.String(), lineMap, nil
+}
+
+// moveFiles moves the PDF and preview generated from the template {from}
+// to the output directory for the tune {to}. It returns the paths of the
+// files that were moved.
+func moveFiles(from, to string) []string {
+	fromBase := strings.TrimSuffix(from, ".ly")
+	os.MkdirAll(filepath.Dir(getPdfPath(to)), 0755) // Both files go in the same directory
+	moved := []string{}
+	if moveFile(fromBase+".pdf", getPdfPath(to)) == nil {
+		moved = append(moved, getPdfPath(to))
+	}
+	if moveFile(fromBase+".preview.png", getPreviewPath(to)) == nil {
+		moved = append(moved, getPreviewPath(to))
+	}
+	return moved
+}
+
+// removeOldOutputs removes the output files of the type {t} that earlier
+// builds made for the tune {name}, so no pages are left over if there are
+// fewer of them now. Only files recorded in the build cache are removed,
+// as the names of other tunes may look like page names.
+func (m *maker) removeOldOutputs(name, t string) {
+	for _, old := range m.cache.files(name) {
+		if outputType(old) == t {
+			os.Remove(old)
 		}
 	}
+}
 
-	return templatePath, nil
+// outputType returns the output type that the output file {path} is made
+// for.
+func outputType(path string) string {
+	if strings.HasSuffix(path, ".preview.png") {
+		return "pdf"
+	}
+	return strings.TrimPrefix(filepath.Ext(path), ".")
 }
 
-func cleanup(path string) {
-	base := strings.TrimSuffix(path, ".ly")
-	// Ignore errors for cleanup operations as files may not exist
-	_ = os.Remove(base + ".log")
-	_ = os.Remove(base + ".ly")
-	_ = os.Remove(base + ".preview.eps")
-	_ = os.Remove(base + ".preview.pdf")
-	_ = os.Remove(base + ".ps")
+// movePngFiles moves the PNG files generated from the template {from} to
+// the output directory for the tune {to}. Lilypond names the pages of
+// multi-page output like "name-page1.png", and they are stored the same
+// way, as "tune-page1.png".
+func movePngFiles(from, to string) []string {
+	fromBase := strings.TrimSuffix(from, ".ly")
+	toBase := strings.TrimSuffix(getPngPath(to), ".png")
+	os.MkdirAll(filepath.Dir(toBase), 0755)
+
+	moved := []string{}
+	if moveFile(fromBase+".png", toBase+".png") == nil {
+		moved = append(moved, toBase+".png")
+	}
+	for i, page := range findPages(fromBase, "-page", ".png") {
+		dst := fmt.Sprintf("%s-page%d.png", toBase, i+1)
+		if moveFile(page, dst) == nil {
+			moved = append(moved, dst)
+		}
+	}
+	return moved
 }
 
-func moveFiles(from, to string) {
+// moveSvgFiles moves the SVG files generated from the template {from} to
+// the output directory for the tune {to}. Lilypond names the pages of
+// multi-page output like "name-1.svg", and they are stored like PNG pages,
+// as "tune-page1.svg". A cropped image is used instead of the pages if
+// there is one.
+func moveSvgFiles(from, to string) []string {
 	fromBase := strings.TrimSuffix(from, ".ly")
-	os.MkdirAll(filepath.Dir(getPdfPath(to)), 0755) // Both files go in the same directory
-	os.Rename(fromBase+".pdf", getPdfPath(to))
-	os.Rename(fromBase+".preview.png", getPreviewPath(to))
+	toBase := strings.TrimSuffix(getSvgPath(to), ".svg")
+	os.MkdirAll(filepath.Dir(toBase), 0755)
+
+	moved := []string{}
+	if moveFile(fromBase+".cropped.svg", toBase+".svg") == nil {
+		return append(moved, toBase+".svg")
+	}
+	if moveFile(fromBase+".svg", toBase+".svg") == nil {
+		moved = append(moved, toBase+".svg")
+	}
+	for i, page := range findPages(fromBase, "-", ".svg") {
+		dst := fmt.Sprintf("%s-page%d.svg", toBase, i+1)
+		if moveFile(page, dst) == nil {
+			moved = append(moved, dst)
+		}
+	}
+	return moved
+}
+
+// moveMidiFiles moves the MIDI files generated from the template {from} to
+// the output directory for the tune {to}. Lilypond writes one file per
+// score, numbering all but the first like "name-1.midi". The files of later
+// scores are stored with the number of the score, like "tune-score2.midi".
+func moveMidiFiles(from, to string) []string {
+	fromBase := strings.TrimSuffix(from, ".ly")
+	toBase := strings.TrimSuffix(getMidiPath(to), ".midi")
+	os.MkdirAll(filepath.Dir(toBase), 0755)
+
+	moved := []string{}
+	if moveFile(fromBase+".midi", toBase+".midi") == nil {
+		moved = append(moved, toBase+".midi")
+	}
+	for i, f := range findPages(fromBase, "-", ".midi") {
+		dst := fmt.Sprintf("%s-score%d.midi", toBase, i+2)
+		if moveFile(f, dst) == nil {
+			moved = append(moved, dst)
+		}
+	}
+	return moved
+}
+
+// moveToRoot moves all PDF and image files in the build directory of the
+// template {from} to the music root, keeping their names. It returns the
+// paths of the files that were moved.
+func moveToRoot(from string) []string {
+	entries, _ := os.ReadDir(filepath.Dir(from))
+	moved := []string{}
+	for _, e := range entries {
+		switch filepath.Ext(e.Name()) {
+		case ".pdf", ".png", ".svg", ".midi":
+			to := pathFromRoot(e.Name())
+			if moveFile(filepath.Join(filepath.Dir(from), e.Name()), to) == nil {
+				moved = append(moved, to)
+			}
+		}
+	}
+	return moved
 }
@@ -0,0 +1,673 @@
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/urfave/cli/v3"
+)
+
+// fakeExitError is returned by fakeRunner to simulate a failing Lilypond.
+type fakeExitError int
+
+func (e fakeExitError) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+func (e fakeExitError) ExitCode() int { return int(e) }
+
+// fakeRunner stands in for Lilypond. It records every call together with
+// the generated template and creates the output files Lilypond would.
+type fakeRunner struct {
+	mu        sync.Mutex
+	calls     [][]string
+	templates []string
+	fail      func(templatePath, template string) ([]byte, error)
+	// Number of pages of PNG output, which is a single file if less than 2
+	pngPages int
+}
+
+func (f *fakeRunner) run(ctx context.Context, dir string, args []string) ([]byte, error) {
+	abs := func(p string) string {
+		if dir != "" && !filepath.IsAbs(p) {
+			return filepath.Join(dir, p)
+		}
+		return p
+	}
+
+	templatePath := abs(args[len(args)-1])
+	template, _ := os.ReadFile(templatePath)
+	base := ""
+	for _, a := range args {
+		if strings.HasPrefix(a, "-o") {
+			base = abs(a[2:])
+		}
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, args)
+	f.templates = append(f.templates, string(template))
+	f.mu.Unlock()
+
+	if f.fail != nil {
+		return f.fail(templatePath, string(template))
+	}
+	// One MIDI file per score, all but the first numbered
+	for i := range strings.Count(string(template), `\midi`) {
+		name := base + ".midi"
+		if i > 0 {
+			name = fmt.Sprintf("%s-%d.midi", base, i)
+		}
+		os.WriteFile(name, []byte("midi"), 0644)
+	}
+	if slices.Contains(args, "-dpreview") {
+		os.WriteFile(base+".preview.png", []byte("preview"), 0644)
+		return []byte("GNU LilyPond 2.24.3\n"), nil
+	}
+	if slices.Contains(args, "-dbackend=svg") {
+		// Pretend the tune has two pages
+		os.WriteFile(base+"-1.svg", []byte("<svg/>"), 0644)
+		os.WriteFile(base+"-2.svg", []byte("<svg/>"), 0644)
+	}
+	if slices.Contains(args, "--pdf") {
+		os.WriteFile(base+".pdf", []byte("pdf"), 0644)
+	}
+	if slices.Contains(args, "--png") {
+		pages := []string{base + ".png"}
+		if f.pngPages > 1 {
+			pages = nil
+			for i := range f.pngPages {
+				pages = append(pages, fmt.Sprintf("%s-page%d.png", base, i+1))
+			}
+		}
+		for _, p := range pages {
+			w, _ := os.Create(p)
+			png.Encode(w, testImage(400, 200, image.Rect(100, 50, 300, 150)))
+			w.Close()
+		}
+	}
+	return []byte("GNU LilyPond 2.24.3\n"), nil
+}
+
+func (f *fakeRunner) version(ctx context.Context) (string, error) {
+	return "GNU LilyPond 2.24.3", nil
+}
+
+// convert pretends to run convert-ly by updating the version statement.
+func (f *fakeRunner) convert(ctx context.Context, file, to string) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	f.calls = append(f.calls, []string{"convert-ly", "--to=" + to, file})
+	f.mu.Unlock()
+
+	return versionStatementRx.ReplaceAll(data, []byte(`\version "`+cmp.Or(to, "2.24.3")+`"`)), nil
+}
+
+// setupMakeTest creates a music root with the given files and makes all
+// Lilypond invocations go to the returned fake runner.
+func setupMakeTest(t *testing.T, files map[string]string) (string, *fakeRunner) {
+	t.Helper()
+	root := t.TempDir()
+	resetConfigForTest()
+	GetConfig().Root = root
+
+	fake := &fakeRunner{}
+	oldRunner := newLilypondRunner
+	newLilypondRunner = func() lilypondRunner { return fake }
+	t.Cleanup(func() {
+		newLilypondRunner = oldRunner
+		resetConfigForTest()
+	})
+
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return root, fake
+}
+
+// runMake runs the make command with {args} the same way the CLI does.
+func runMake(args ...string) error {
+	cmd := &cli.Command{Name: "make", Flags: makeFlags(), Action: makeCmd.Action}
+	return cmd.Run(context.Background(), append([]string{"make", "--jobs", "1"}, args...))
+}
+
+const testTune = `\header { title = "Test Tune" }
+{ c'4 d' e' }
+%%% START SKIP
+\markup "Only in the full score"
+%%% END SKIP
+`
+
+func Test_makeCmd_pdf(t *testing.T) {
+	root, fake := setupMakeTest(t, map[string]string{"jigs/tune.ly": testTune})
+
+	if err := runMake("jigs/tune"); err != nil {
+		t.Fatalf("make failed: %v", err)
+	}
+
+	if len(fake.calls) != 2 {
+		t.Fatalf("lilypond called %d times, want 2", len(fake.calls))
+	}
+	preview, pdf := fake.templates[0], fake.templates[1]
+	for _, want := range []string{"#(set-global-staff-size 15)", `title = "Test Tune"`, "c'4 d' e'"} {
+		if !strings.Contains(preview, want) || !strings.Contains(pdf, want) {
+			t.Errorf("generated templates should contain %q", want)
+		}
+	}
+	if strings.Contains(preview, "Only in the full score") {
+		t.Errorf("preview template should not contain the skipped region")
+	}
+	if !strings.Contains(pdf, "Only in the full score") {
+		t.Errorf("PDF template should contain the skipped region")
+	}
+
+	for _, p := range []string{"_output/jigs/tune.pdf", "_output/jigs/tune.preview.png"} {
+		if _, err := os.Stat(filepath.Join(root, p)); err != nil {
+			t.Errorf("expected output file %s: %v", p, err)
+		}
+	}
+	entries, _ := os.ReadDir(root)
+	for _, e := range entries {
+		if e.Name() != "jigs" && e.Name() != outputDir {
+			t.Errorf("unexpected file %s left in the music root", e.Name())
+		}
+	}
+	if !slices.Contains(fake.calls[0], "-I"+root) {
+		t.Errorf("the music root should be on the include path: %v", fake.calls[0])
+	}
+}
+
+func Test_makeCmd_svg(t *testing.T) {
+	root, fake := setupMakeTest(t, map[string]string{"jigs/tune.ly": testTune})
+	// A stale page from an earlier build with more pages, and the output of
+	// another tune with a name like a page
+	os.MkdirAll(filepath.Join(root, "_output/jigs"), 0755)
+	os.WriteFile(filepath.Join(root, "_output/jigs/tune-page3.svg"), []byte("<svg/>"), 0644)
+	os.WriteFile(filepath.Join(root, "_output/jigs/tune-3.svg"), []byte("<svg/>"), 0644)
+	cache := loadBuildCache()
+	cache.addFiles(filepath.Join(root, "jigs/tune.ly"), []string{filepath.Join(root, "_output/jigs/tune-page3.svg")})
+	if err := cache.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runMake("--type", "svg", "jigs/tune"); err != nil {
+		t.Fatalf("make failed: %v", err)
+	}
+
+	if len(fake.calls) != 1 {
+		t.Fatalf("lilypond called %d times, want 1", len(fake.calls))
+	}
+	for _, p := range []string{"_output/jigs/tune-page1.svg", "_output/jigs/tune-page2.svg", "_output/jigs/tune-3.svg"} {
+		if _, err := os.Stat(filepath.Join(root, p)); err != nil {
+			t.Errorf("expected output file %s: %v", p, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "_output/jigs/tune-page3.svg")); err == nil {
+		t.Errorf("stale page should have been removed")
+	}
+}
+
+func Test_makeCmd_midi(t *testing.T) {
+	root, fake := setupMakeTest(t, map[string]string{"jigs/tune.ly": testTune + "\\score { { c'1 } }\n"})
+	// The output of another tune with a name like a numbered score
+	os.MkdirAll(filepath.Join(root, "_output/jigs"), 0755)
+	os.WriteFile(filepath.Join(root, "_output/jigs/tune-1.midi"), []byte("midi"), 0644)
+
+	if err := runMake("--type", "midi", "jigs/tune"); err != nil {
+		t.Fatalf("make failed: %v", err)
+	}
+
+	if len(fake.calls) != 1 {
+		t.Fatalf("lilypond called %d times, want 1", len(fake.calls))
+	}
+	if !strings.Contains(fake.templates[0], `\score { { c'1 }  \midi { } }`) {
+		t.Errorf("a \\midi block should be added to the score:\n%s", fake.templates[0])
+	}
+	if !strings.Contains(fake.templates[0], `\score { { c'4 d' e' }  \midi { } }`) {
+		t.Errorf("music at the top level should get a \\midi block too:\n%s", fake.templates[0])
+	}
+	for _, p := range []string{"_output/jigs/tune.midi", "_output/jigs/tune-score2.midi", "_output/jigs/tune-1.midi"} {
+		if _, err := os.Stat(filepath.Join(root, p)); err != nil {
+			t.Errorf("expected output file %s: %v", p, err)
+		}
+	}
+
+	// The file of a score that is gone is removed
+	os.WriteFile(filepath.Join(root, "jigs/tune.ly"), []byte(testTune), 0644)
+	if err := runMake("--type", "midi", "jigs/tune"); err != nil {
+		t.Fatalf("make failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "_output/jigs/tune-score2.midi")); err == nil {
+		t.Errorf("stale score file should have been removed")
+	}
+	if _, err := os.Stat(filepath.Join(root, "_output/jigs/tune-1.midi")); err != nil {
+		t.Errorf("the output of another tune should be kept: %v", err)
+	}
+
+	if err := runMake("--audio", "wav", "jigs/tune"); err == nil {
+		t.Errorf("--audio without --type midi should fail")
+	}
+}
+
+func Test_makeCmd_audio(t *testing.T) {
+	root, _ := setupMakeTest(t, map[string]string{"jigs/tune.ly": testTune})
+	GetConfig().Audio = AudioConfig{Soundfont: "test.sf2", Synth: "cp {{.midi}} {{.output}}"}
+
+	if err := runMake("--type", "midi", "--audio", "wav", "jigs/tune"); err != nil {
+		t.Fatalf("make failed: %v", err)
+	}
+
+	for _, p := range []string{"_output/jigs/tune.midi", "_output/jigs/tune.wav"} {
+		if _, err := os.Stat(filepath.Join(root, p)); err != nil {
+			t.Errorf("expected output file %s: %v", p, err)
+		}
+	}
+}
+
+func Test_makeCmd_multipleTypes(t *testing.T) {
+	root, fake := setupMakeTest(t, map[string]string{"jigs/tune.ly": testTune + "\\score { { c'1 } }\n"})
+
+	if err := runMake("--type", "pdf,png,svg,midi,pdf", "jigs/tune"); err != nil {
+		t.Fatalf("make failed: %v", err)
+	}
+
+	// Preview, PDF and PNG together, SVG, and MIDI
+	if len(fake.calls) != 4 {
+		t.Fatalf("lilypond called %d times, want 4", len(fake.calls))
+	}
+	if !slices.Contains(fake.calls[1], "--pdf") || !slices.Contains(fake.calls[1], "--png") {
+		t.Errorf("PDF and PNG should be created by the same run: %v", fake.calls[1])
+	}
+	if strings.Contains(fake.templates[1], `\midi`) {
+		t.Errorf("the printed output should not have a \\midi block:\n%s", fake.templates[1])
+	}
+	if !slices.Contains(fake.calls[3], "-dno-print-pages") || !strings.Contains(fake.templates[3], `\midi`) {
+		t.Errorf("MIDI should be created by a run of its own: %v", fake.calls[3])
+	}
+	for _, p := range []string{"tune.pdf", "tune.preview.png", "tune.png", "tune-page1.svg", "tune-page2.svg", "tune.midi"} {
+		if _, err := os.Stat(filepath.Join(root, "_output/jigs", p)); err != nil {
+			t.Errorf("expected output file %s: %v", p, err)
+		}
+	}
+
+	if err := runMake("--type", "pdf,gif", "jigs/tune"); err == nil {
+		t.Errorf("unknown output type should fail")
+	}
+}
+
+func Test_makeCmd_transpose(t *testing.T) {
+	root, fake := setupMakeTest(t, map[string]string{"jigs/tune.ly": "\\score { { c'1 } }\n{ d'1 }\n"})
+
+	if err := runMake("jigs/tune"); err != nil {
+		t.Fatalf("make failed: %v", err)
+	}
+	if err := runMake("--transpose", "c:bes", "jigs/tune"); err != nil {
+		t.Fatalf("transposed make failed: %v", err)
+	}
+
+	if !strings.Contains(fake.templates[3], `\transpose c bes  { c'1 }`) {
+		t.Errorf("the score should be transposed:\n%s", fake.templates[3])
+	}
+	if !strings.Contains(fake.templates[3], `\score { \transpose c bes  { d'1 } }`) {
+		t.Errorf("music at the top level should be transposed:\n%s", fake.templates[3])
+	}
+	for _, p := range []string{"tune.pdf", "tune.preview.png", "tune-bes.pdf", "tune-bes.preview.png"} {
+		if _, err := os.Stat(filepath.Join(root, "_output/jigs", p)); err != nil {
+			t.Errorf("expected output file %s: %v", p, err)
+		}
+	}
+}
+
+func Test_makeCmd_allParts(t *testing.T) {
+	tune := "melodyPart = { c'1 }\nsecondsPart = { e'1 }\n\\score { << \\melodyPart \\secondsPart >> }\n"
+	root, fake := setupMakeTest(t, map[string]string{"jigs/tune.ly": tune})
+
+	if err := runMake("--all-parts", "jigs/tune"); err != nil {
+		t.Fatalf("make failed: %v", err)
+	}
+
+	if len(fake.calls) != 6 {
+		t.Fatalf("lilypond called %d times, want 6", len(fake.calls))
+	}
+	if !strings.Contains(fake.templates[3], `\score { \melodyPart `) || strings.Contains(fake.templates[3], `\melodyPart \secondsPart`) {
+		t.Errorf("the melody template should only have the melody part:\n%s", fake.templates[3])
+	}
+	for _, p := range []string{"tune.pdf", "tune-melody.pdf", "tune-seconds.pdf"} {
+		if _, err := os.Stat(filepath.Join(root, "_output/jigs", p)); err != nil {
+			t.Errorf("expected output file %s: %v", p, err)
+		}
+	}
+
+	if err := runMake("--part", "drums", "jigs/tune"); err == nil {
+		t.Errorf("make should fail for a missing part")
+	}
+}
+
+func Test_makeCmd_tuneOptions(t *testing.T) {
+	tune := "%% domusic: landscape: true, staff-size: 17\n" + testTune
+	_, fake := setupMakeTest(t, map[string]string{"tune.ly": tune, "bad.ly": "%% domusic: colour: red\n" + testTune})
+
+	if err := runMake("--staff-size", "20", "tune"); err != nil {
+		t.Fatalf("make failed: %v", err)
+	}
+	for _, want := range []string{"#(set-global-staff-size 20)", `"a4" 'landscape`} {
+		if !strings.Contains(fake.templates[1], want) {
+			t.Errorf("generated template should contain %q:\n%s", want, fake.templates[1])
+		}
+	}
+
+	if err := runMake("bad"); err == nil {
+		t.Errorf("make should fail for an unknown tune option")
+	}
+}
+
+func Test_makeCmd_regions(t *testing.T) {
+	tune := testTune + "%%% START ONLY png\n\\markup \"Only in the PNG\"\n%%% END ONLY png\n"
+	_, fake := setupMakeTest(t, map[string]string{"tune.ly": tune, "bad.ly": testTune + "%%% START SKIP pdf\n"})
+
+	if err := runMake("--type", "pdf,png", "tune"); err != nil {
+		t.Fatalf("make failed: %v", err)
+	}
+
+	// A region for PNG only means PDF and PNG need separate documents
+	if len(fake.calls) != 3 {
+		t.Fatalf("lilypond called %d times, want 3", len(fake.calls))
+	}
+	if strings.Contains(fake.templates[1], "Only in the PNG") || !strings.Contains(fake.templates[2], "Only in the PNG") {
+		t.Errorf("only the PNG template should contain the PNG region")
+	}
+
+	if err := runMake("bad"); err == nil || len(fake.calls) != 3 {
+		t.Errorf("make should fail for an unclosed region without running lilypond")
+	}
+}
+
+func Test_makeCmd_post(t *testing.T) {
+	root, fake := setupMakeTest(t, map[string]string{"tune.ly": testTune})
+	GetConfig().Post = map[string]PostPreset{
+		"square": {Width: 100, Aspect: "1:1", Padding: 10, Footer: "{{.title}} - {{.url}}", URL: "example.com"},
+	}
+
+	if err := runMake("--post-preset", "square", "tune"); err != nil {
+		t.Fatalf("make failed: %v", err)
+	}
+
+	if !slices.Contains(fake.calls[0], "-dresolution=84") {
+		t.Errorf("post images should use the default post resolution: %v", fake.calls[0])
+	}
+	if !strings.HasSuffix(fake.templates[0], "\n\\markup \\fill-line { \"Test Tune - example.com\" }\n") {
+		t.Errorf("the footer should follow the music:\n%s", fake.templates[0])
+	}
+	if !strings.Contains(fake.templates[0], `tagline = ""`) {
+		t.Errorf("post images should have no tagline:\n%s", fake.templates[0])
+	}
+	f, err := os.Open(filepath.Join(root, "_output/tune.png"))
+	if err != nil {
+		t.Fatalf("no PNG file: %v", err)
+	}
+	defer f.Close()
+	if cfg, err := png.DecodeConfig(f); err != nil || cfg.Width != 100 || cfg.Height != 100 {
+		t.Errorf("post image is %dx%d (%v), want 100x100", cfg.Width, cfg.Height, err)
+	}
+
+	if err := runMake("--post", "--post-preset", "missing", "tune"); err == nil {
+		t.Errorf("make should fail for an unknown post preset")
+	}
+
+	// Plain --post still works and uses the default preset
+	if err := runMake("--post", "--force", "tune"); err != nil {
+		t.Fatalf("make --post failed: %v", err)
+	}
+	last := len(fake.calls) - 1
+	if !slices.Contains(fake.calls[last], "-dresolution=84") || strings.Contains(fake.templates[last], "example.com") {
+		t.Errorf("--post should use the default preset: %v\n%s", fake.calls[last], fake.templates[last])
+	}
+}
+
+func Test_makeCmd_pngPages(t *testing.T) {
+	root, fake := setupMakeTest(t, map[string]string{"tune.ly": testTune})
+	fake.pngPages = 2
+
+	if err := runMake("--type", "png", "tune"); err != nil {
+		t.Fatalf("make failed: %v", err)
+	}
+	for _, p := range []string{"_output/tune-page1.png", "_output/tune-page2.png"} {
+		if _, err := os.Stat(filepath.Join(root, p)); err != nil {
+			t.Errorf("expected output file %s: %v", p, err)
+		}
+	}
+
+	if err := runMake("--type", "png", "--stitch", "--force", "tune"); err != nil {
+		t.Fatalf("make failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "_output/tune-page1.png")); err == nil {
+		t.Errorf("pages from the earlier build should have been removed")
+	}
+	f, err := os.Open(filepath.Join(root, "_output/tune.png"))
+	if err != nil {
+		t.Fatalf("no stitched PNG file: %v", err)
+	}
+	defer f.Close()
+	if cfg, err := png.DecodeConfig(f); err != nil || cfg.Width != 400 || cfg.Height != 400 {
+		t.Errorf("stitched image is %dx%d (%v), want 400x400", cfg.Width, cfg.Height, err)
+	}
+}
+
+func Test_makeCmd_pngPagesOtherTune(t *testing.T) {
+	root, fake := setupMakeTest(t, map[string]string{"march.ly": testTune, "march-2.ly": testTune})
+
+	if err := runMake("--type", "png", "march-2"); err != nil {
+		t.Fatalf("make failed: %v", err)
+	}
+	fake.pngPages = 2
+	if err := runMake("--type", "png", "march"); err != nil {
+		t.Fatalf("make failed: %v", err)
+	}
+	fake.pngPages = 0
+	if err := runMake("--type", "png", "--force", "march"); err != nil {
+		t.Fatalf("make failed: %v", err)
+	}
+	for _, p := range []string{"_output/march.png", "_output/march-2.png"} {
+		if _, err := os.Stat(filepath.Join(root, p)); err != nil {
+			t.Errorf("expected output file %s: %v", p, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "_output/march-page2.png")); err == nil {
+		t.Errorf("pages from the earlier build should have been removed")
+	}
+}
+
+func Test_makeCmd_versionWarning(t *testing.T) {
+	root, _ := setupMakeTest(t, map[string]string{"new.ly": "% New tune\n\\version \"2.25.10\"\n" + testTune})
+
+	reportFile := filepath.Join(root, "report.json")
+	if err := runMake("--report-file", reportFile, "new"); err != nil {
+		t.Fatalf("make failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(reportFile)
+	var report makeReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("invalid report: %v", err)
+	}
+	diags := report.Tunes[0].Diagnostics
+	if len(diags) != 1 || diags[0].Line != 2 || !strings.Contains(diags[0].Message, "needs Lilypond 2.25.10") {
+		t.Errorf("diagnostics = %v, want a version warning on line 2", diags)
+	}
+}
+
+func Test_makeCmd_keep(t *testing.T) {
+	root, _ := setupMakeTest(t, map[string]string{"jigs/tune.ly": testTune})
+
+	if err := runMake("--keep", "jigs/tune"); err != nil {
+		t.Fatalf("make failed: %v", err)
+	}
+
+	for _, p := range []string{"__jigs_tune/__jigs_tune.ly", "__jigs_tune/__jigs_tune.log", "_output/jigs/tune.pdf"} {
+		if _, err := os.Stat(filepath.Join(root, p)); err != nil {
+			t.Errorf("expected file %s: %v", p, err)
+		}
+	}
+}
+
+func Test_makeCmd_skipsUpToDate(t *testing.T) {
+	root, fake := setupMakeTest(t, map[string]string{"tune.ly": testTune})
+
+	if err := runMake("tune"); err != nil {
+		t.Fatalf("first make failed: %v", err)
+	}
+	if err := runMake("tune"); err != nil {
+		t.Fatalf("second make failed: %v", err)
+	}
+	if len(fake.calls) != 2 {
+		t.Errorf("unchanged tune was built again: %d calls, want 2", len(fake.calls))
+	}
+
+	if err := runMake("--force", "tune"); err != nil {
+		t.Fatalf("forced make failed: %v", err)
+	}
+	if len(fake.calls) != 4 {
+		t.Errorf("--force should build again: %d calls, want 4", len(fake.calls))
+	}
+
+	os.WriteFile(filepath.Join(root, "tune.ly"), []byte(testTune+"{ f' }\n"), 0644)
+	if err := runMake("tune"); err != nil {
+		t.Fatalf("make after edit failed: %v", err)
+	}
+	if len(fake.calls) != 6 {
+		t.Errorf("changed tune should be built: %d calls, want 6", len(fake.calls))
+	}
+
+	GetConfig().Lilypond.Args = []string{"-dno-point-and-click"}
+	if err := runMake("tune"); err != nil {
+		t.Fatalf("make after config change failed: %v", err)
+	}
+	if len(fake.calls) != 8 {
+		t.Errorf("tune should be built with the new Lilypond args: %d calls, want 8", len(fake.calls))
+	}
+}
+
+func Test_makeCmd_openLogsInTurn(t *testing.T) {
+	root, fake := setupMakeTest(t, map[string]string{"a.ly": testTune, "b.ly": testTune, "c.ly": testTune})
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	fake.fail = func(templatePath, template string) ([]byte, error) {
+		return []byte("Segmentation fault\n"), fakeExitError(1)
+	}
+	// The editor notes when it starts and stops, taking a while in between,
+	// but only if the log is there
+	opened := filepath.Join(root, "opened.txt")
+	editor := filepath.Join(root, "editor.sh")
+	script := fmt.Sprintf("#!/bin/sh\ntest -f \"$1\" || exit 1\necho start >> %s\nsleep 0.05\necho stop >> %s\n", opened, opened)
+	if err := os.WriteFile(editor, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	GetConfig().LyEditor = editor
+
+	if err := runMake("--jobs", "3", "a", "b", "c"); err == nil {
+		t.Fatal("make should fail when lilypond fails")
+	}
+
+	data, _ := os.ReadFile(opened)
+	if got := string(data); got != strings.Repeat("start\nstop\n", 3) {
+		t.Errorf("log files should be opened one at a time for each failed tune:\n%s", got)
+	}
+	if dirs, _ := filepath.Glob(filepath.Join(tmp, "domusic-*")); len(dirs) != 0 {
+		t.Errorf("build directories should be removed once the logs are shown, found %v", dirs)
+	}
+}
+
+func Test_makeCmd_templateWithoutNewline(t *testing.T) {
+	root, fake := setupMakeTest(t, map[string]string{"tune.ly": testTune})
+	GetConfig().Template.Make = "%% Custom template\n\\paper { }"
+	fake.fail = func(templatePath, template string) ([]byte, error) {
+		lines := strings.Split(template, "\n")
+		lineNo := slices.IndexFunc(lines, func(l string) bool { return strings.Contains(l, "c'4") }) + 1
+		return []byte(fmt.Sprintf("%s:%d:3: error: not a note\n", templatePath, lineNo)), fakeExitError(1)
+	}
+
+	reportFile := filepath.Join(root, "report.json")
+	if err := runMake("--report-file", reportFile, "tune"); err == nil {
+		t.Fatal("make should fail when lilypond fails")
+	}
+	if !strings.Contains(fake.templates[0], "\\paper { }\n\\header") {
+		t.Errorf("the tune should start on a line of its own:\n%s", fake.templates[0])
+	}
+	data, err := os.ReadFile(reportFile)
+	if err != nil {
+		t.Fatalf("no report written: %v", err)
+	}
+	var report makeReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("invalid report: %v", err)
+	}
+	if diags := report.Tunes[0].Diagnostics; len(diags) != 1 || diags[0].Line != 2 {
+		t.Errorf("diagnostics = %v, want an error on line 2", diags)
+	}
+}
+
+func Test_makeCmd_failure(t *testing.T) {
+	root, fake := setupMakeTest(t, map[string]string{"tune.ly": testTune, "other.ly": testTune})
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	fake.fail = func(templatePath, template string) ([]byte, error) {
+		// Report an error on the line with the notes, which is line 2 of the tune
+		lines := strings.Split(template, "\n")
+		lineNo := slices.IndexFunc(lines, func(l string) bool { return strings.Contains(l, "c'4") }) + 1
+		log := fmt.Sprintf("%s:%d:3: error: not a note\n", templatePath, lineNo)
+		return []byte(log), fakeExitError(1)
+	}
+
+	reportFile := filepath.Join(root, "report.json")
+	err := runMake("--fail-fast", "--report-file", reportFile, "tune", "other")
+	if err == nil {
+		t.Fatal("make should fail when lilypond fails")
+	}
+	if len(fake.calls) != 1 {
+		t.Errorf("--fail-fast should stop after the first failure: %d calls", len(fake.calls))
+	}
+
+	data, err := os.ReadFile(reportFile)
+	if err != nil {
+		t.Fatalf("no report written: %v", err)
+	}
+	var report makeReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("invalid report: %v", err)
+	}
+	if report.Failed != 1 || len(report.Tunes) != 1 {
+		t.Fatalf("report = %+v, want one failed tune", report)
+	}
+	tune := report.Tunes[0]
+	if tune.Status != "failed" || tune.ExitCode != 1 {
+		t.Errorf("tune status = %s, exit code %d, want failed, 1", tune.Status, tune.ExitCode)
+	}
+	want := diagnostic{File: filepath.Join(root, "tune.ly"), Line: 2, Column: 3, Severity: "error", Message: "not a note"}
+	if len(tune.Diagnostics) != 1 || tune.Diagnostics[0] != want {
+		t.Errorf("diagnostics = %v, want [%v]", tune.Diagnostics, want)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "__tune")); err == nil {
+		t.Errorf("failed builds should only be kept in the music root with --keep")
+	}
+	if dirs, _ := filepath.Glob(filepath.Join(tmp, "domusic-*")); len(dirs) != 0 {
+		t.Errorf("build directories should be removed, found %v", dirs)
+	}
+}
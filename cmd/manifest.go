@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/urfave/cli/v3"
+	"gopkg.in/yaml.v3"
+)
+
+var collectionBuildCmd = &cli.Command{
+	Name:      "build",
+	Usage:     "Generate a collection document from the manifest <manifest>",
+	ArgsUsage: "<manifest>",
+	Arguments: []cli.Argument{
+		&cli.StringArg{
+			Name: "manifest",
+		},
+	},
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		file := cmd.StringArg("manifest")
+		if file == "" {
+			return printAndReturnError("build needs a manifest file")
+		}
+		manifest, err := loadManifest(file)
+		if err != nil {
+			return printAndReturnError("%w", err)
+		}
+
+		sections, err := manifest.collectionSections(time.Now())
+		if err != nil {
+			return printAndReturnError("%s: %w", file, err)
+		}
+		info := collectionInfo{
+			title:    manifest.Title,
+			subtitle: manifest.Subtitle,
+			cover:    manifest.Cover,
+		}
+		if info.title == "" {
+			info.title = cmd.String("title")
+		}
+		doc, err := generateCollection(cmd, info, sections)
+		if err != nil {
+			return printAndReturnError("%w", err)
+		}
+
+		output := manifest.Output
+		if cmd.IsSet("output") {
+			output = cmd.String("output")
+		}
+		if output != "" {
+			return compileCollection(ctx, cmd, output, doc)
+		}
+		fmt.Println(doc)
+		return nil
+	},
+}
+
+// collectionManifest describes a collection: its titles, the text for the
+// cover page, and the tunes in it, grouped in sections. {Output} is the name
+// the collection is compiled to, like the output flag of the collection
+// command.
+type collectionManifest struct {
+	Title    string            `yaml:"title"`
+	Subtitle string            `yaml:"subtitle"`
+	Cover    string            `yaml:"cover"`
+	Output   string            `yaml:"output"`
+	Sections []manifestSection `yaml:"sections"`
+}
+
+// manifestSection is a section of a collection manifest. It has the tunes
+// listed in {Tunes} in that order, followed by the tunes selected by {Query}
+// that are not already listed, in the usual collection order.
+type manifestSection struct {
+	Heading string         `yaml:"heading"`
+	Tunes   []manifestTune `yaml:"tunes"`
+	Query   *tuneQuery     `yaml:"query"`
+}
+
+// manifestTune is a tune in a collection manifest, with the settings that
+// apply to it only. It can be written as just the path of the tune.
+type manifestTune struct {
+	Path      string `yaml:"path"`
+	Transpose string `yaml:"transpose"`
+	StaffSize int    `yaml:"staff-size"`
+	PageBreak bool   `yaml:"page-break"`
+}
+
+// UnmarshalYAML allows a tune to be given as a plain path. Unknown fields
+// are errors, like in the rest of the manifest.
+func (t *manifestTune) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&t.Path)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		if !slices.Contains([]string{"path", "transpose", "staff-size", "page-break"}, key.Value) {
+			return fmt.Errorf("line %d: field %s not found in tune", key.Line, key.Value)
+		}
+	}
+
+	// A different type avoids calling this method again
+	type plain manifestTune
+	dec := (*plain)(t)
+	return node.Decode(dec)
+}
+
+// loadManifest reads the collection manifest {file}. Unknown fields are
+// reported as errors, since they are most likely misspelled.
+func loadManifest(file string) (*collectionManifest, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest %s: %w", file, err)
+	}
+
+	manifest := &collectionManifest{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", file, err)
+	}
+	if len(manifest.Sections) == 0 {
+		return nil, fmt.Errorf("manifest %s has no sections", file)
+	}
+
+	return manifest, nil
+}
+
+// collectionSections returns the sections of the manifest with the tunes
+// of each of them. Durations in queries are counted back from {now}.
+func (c *collectionManifest) collectionSections(now time.Time) ([]collectionSection, error) {
+	sections := []collectionSection{}
+	for i, s := range c.Sections {
+		section := collectionSection{heading: s.Heading}
+		listed := map[string]bool{}
+		for _, t := range s.Tunes {
+			if t.Path == "" {
+				return nil, fmt.Errorf("section %d has a tune without a path", i+1)
+			}
+			src := getSourcePath(t.Path)
+			listed[makeRel(src)] = true
+			section.tunes = append(section.tunes, collectionTune{
+				path:      src,
+				include:   makeRel(src),
+				transpose: t.Transpose,
+				staffSize: t.StaffSize,
+				pageBreak: t.PageBreak,
+			})
+		}
+
+		if s.Query != nil {
+			filter, err := newTuneFilter(*s.Query, now)
+			if err != nil {
+				return nil, fmt.Errorf("section %d: %w", i+1, err)
+			}
+			tunes, err := listTunes(filter)
+			if err != nil {
+				return nil, err
+			}
+			sort.SliceStable(tunes, func(i, j int) bool {
+				return pathForSort(tunes[i].Path) < pathForSort(tunes[j].Path)
+			})
+			for _, t := range tunes {
+				if !listed[t.Path] {
+					section.tunes = append(section.tunes, collectionTune{path: pathFromRoot(t.Path), include: t.Path})
+				}
+			}
+		}
+
+		if len(section.tunes) == 0 {
+			printWarning("section %d of the manifest has no tunes", i+1)
+		}
+		sections = append(sections, section)
+	}
+
+	return sections, nil
+}
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v3"
+)
+
+func Test_loadManifest(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		want    []manifestTune
+		wantErr bool
+	}{
+		{"plain_paths", "sections:\n  - tunes: [jigs/a, jigs/b.ly]\n", []manifestTune{{Path: "jigs/a"}, {Path: "jigs/b.ly"}}, false},
+		{"overrides", "sections:\n  - tunes:\n    - path: jigs/a\n      transpose: c:d\n      staff-size: 18\n      page-break: true\n", []manifestTune{{Path: "jigs/a", Transpose: "c:d", StaffSize: 18, PageBreak: true}}, false},
+		{"unknown_tune_field", "sections:\n  - tunes:\n    - path: jigs/a\n      staffsize: 18\n", nil, true},
+		{"unknown_field", "titel: Book\nsections:\n  - tunes: [jigs/a]\n", nil, true},
+		{"no_sections", "title: Book\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "book.yaml")
+			if err := os.WriteFile(file, []byte(tt.yaml), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := loadManifest(file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadManifest() error = %v, want error %t", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got.Sections[0].Tunes, tt.want) {
+				t.Errorf("loadManifest() tunes = %+v, want %+v", got.Sections[0].Tunes, tt.want)
+			}
+		})
+	}
+}
+
+// runCollection runs the collection command with {args} the same way the
+// CLI does.
+func runCollection(args ...string) error {
+	cmd := &cli.Command{
+		Name:   "collection",
+		Flags:  collectionFlags(),
+		Action: collectionCmd.Action,
+		Commands: []*cli.Command{{
+			Name:      "build",
+			Arguments: collectionBuildCmd.Arguments,
+			Action:    collectionBuildCmd.Action,
+		}},
+	}
+	return cmd.Run(context.Background(), append([]string{"collection"}, args...))
+}
+
+func Test_collectionBuildCmd(t *testing.T) {
+	root, fake := setupMakeTest(t, map[string]string{
+		"jigs/old.ly":    `\header { title = "Old Jig" meter = "Jig" }`,
+		"reels/b.ly":     "\\include \"reels/defs.ily\"\n\\header { title = \"B Reel\" meter = \"Reel\" }\n\\score { { c4 } }",
+		"reels/a.ly":     `\header { title = "A Reel" meter = "Reel" }`,
+		"reels/defs.ily": "",
+		"book.yaml": `title: Book
+cover: Collected tunes
+output: book
+sections:
+  - heading: Reels
+    tunes:
+      - path: reels/b
+        transpose: c:d
+        page-break: true
+    query:
+      type: reel
+  - tunes: [jigs/old]
+`,
+	})
+
+	if err := runCollection("build", filepath.Join(root, "book.yaml")); err != nil {
+		t.Fatalf("collection build failed: %v", err)
+	}
+
+	if len(fake.calls) != 2 {
+		t.Fatalf("lilypond called %d times, want 2", len(fake.calls))
+	}
+	if !slices.Contains(fake.calls[0], "-dresolution=144") {
+		t.Errorf("preview should use the default resolution: %v", fake.calls[0])
+	}
+	doc := fake.templates[1]
+	want := []string{
+		`title = "Book"`,
+		`\markup \fill-line { "Collected tunes" }`,
+		`\tocItem \markup \bold "Reels"`,
+		"\\pageBreak\n\\tocItem \\markup \"B Reel\"",
+		`\include "` + filepath.Join(root, "reels/defs.ily") + `"`,
+		`\transpose c d`,
+		"\\tocItem \\markup \"A Reel\"\n\\include \"reels/a.ly\"",
+		"\\tocItem \\markup \"Old Jig\"\n\\include \"jigs/old.ly\"",
+	}
+	pos := 0
+	for _, w := range want {
+		i := strings.Index(doc[pos:], w)
+		if i < 0 {
+			t.Fatalf("generated collection should contain %q after position %d:\n%s", w, pos, doc)
+		}
+		pos += i + len(w)
+	}
+	if strings.Count(doc, `\tocItem \markup "B Reel"`) != 1 {
+		t.Errorf("tunes listed in a section should not be added again by its query")
+	}
+	if strings.Contains(doc, "set-global-staff-size") {
+		t.Errorf("the collection should not be put into the make template")
+	}
+
+	for _, p := range []string{"_output/book.pdf", "_output/book.preview.png"} {
+		if _, err := os.Stat(filepath.Join(root, p)); err != nil {
+			t.Errorf("expected output file %s: %v", p, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "book.ly")); err == nil {
+		t.Errorf("the collection document should be removed after the build")
+	}
+}
+
+func Test_collectionCmd_outputExists(t *testing.T) {
+	root, fake := setupMakeTest(t, map[string]string{
+		"jigs/old.ly": `\header { title = "Old Jig" }`,
+		"book.ly":     "% Not a collection",
+	})
+
+	if err := runCollection("--output", "book", "jigs/old"); err == nil {
+		t.Fatalf("collection should fail when the output file exists")
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "book.ly")); string(data) != "% Not a collection" {
+		t.Errorf("existing file was changed to %q", data)
+	}
+	if len(fake.calls) != 0 {
+		t.Errorf("lilypond called %d times, want 0", len(fake.calls))
+	}
+}
+
+func Test_collectionCmd_failure(t *testing.T) {
+	root, fake := setupMakeTest(t, map[string]string{
+		"jigs/old.ly": `\header { title = "Old Jig" }`,
+	})
+	fake.fail = func(templatePath, template string) ([]byte, error) {
+		return []byte("Segmentation fault\n"), fakeExitError(1)
+	}
+
+	if err := runCollection("--output", "book", "jigs/old"); err == nil {
+		t.Fatalf("collection should fail when lilypond fails")
+	}
+	entries, _ := os.ReadDir(root)
+	for _, e := range entries {
+		if e.Name() != "jigs" && e.Name() != "_output" {
+			t.Errorf("failed build should leave nothing in the music root, found %s", e.Name())
+		}
+	}
+}
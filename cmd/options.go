@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// An options line at the top of a tune, like
+// "%% domusic: landscape: true, staff-size: 17"
+var tuneOptionsRx = regexp.MustCompile(`^%+\s*domusic:(.*)$`)
+
+// tuneOptionKinds lists the make flags that can be set in a tune, and the
+// kind of value each of them takes.
+var tuneOptionKinds = map[string]string{
+	"format":          "string",
+	"landscape":       "bool",
+	"paper-size":      "string",
+	"point-and-click": "bool",
+	"staff-size":      "int",
+	"view-spacing":    "bool",
+}
+
+// tuneOptions are the build options set in a tune. Flags given on the
+// command line always take precedence over them.
+type tuneOptions struct {
+	cmd    *cli.Command
+	values map[string]any
+}
+
+// parseTuneOptions reads the options lines in the comments at the top of
+// {src}. Reading stops at the first line that is neither empty nor a
+// comment. Unknown options and values of the wrong kind are errors.
+func parseTuneOptions(cmd *cli.Command, src []byte) (tuneOptions, error) {
+	opts := tuneOptions{cmd: cmd, values: map[string]any{}}
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "%") {
+			break
+		}
+		m := tuneOptionsRx.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		for _, item := range strings.Split(m[1], ",") {
+			if strings.TrimSpace(item) == "" {
+				continue
+			}
+			if err := opts.set(item); err != nil {
+				return opts, err
+			}
+		}
+	}
+
+	return opts, nil
+}
+
+// set parses a single "name: value" {item} and stores the value.
+func (o tuneOptions) set(item string) error {
+	name, value, ok := strings.Cut(item, ":")
+	name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+	if !ok || value == "" {
+		return fmt.Errorf("invalid tune option %q, use name: value", strings.TrimSpace(item))
+	}
+
+	var err error
+	switch tuneOptionKinds[name] {
+	case "string":
+		o.values[name] = value
+	case "bool":
+		o.values[name], err = strconv.ParseBool(value)
+	case "int":
+		o.values[name], err = strconv.Atoi(value)
+	default:
+		return fmt.Errorf("unknown tune option %s", name)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value %s for tune option %s", value, name)
+	}
+
+	return nil
+}
+
+// String returns the value of the string flag {name}.
+func (o tuneOptions) String(name string) string {
+	if v, ok := o.values[name]; ok && !o.cmd.IsSet(name) {
+		return v.(string)
+	}
+	return o.cmd.String(name)
+}
+
+// Int returns the value of the int flag {name}.
+func (o tuneOptions) Int(name string) int {
+	if v, ok := o.values[name]; ok && !o.cmd.IsSet(name) {
+		return v.(int)
+	}
+	return o.cmd.Int(name)
+}
+
+// Bool returns the value of the bool flag {name}.
+func (o tuneOptions) Bool(name string) bool {
+	if v, ok := o.values[name]; ok && !o.cmd.IsSet(name) {
+		return v.(bool)
+	}
+	return o.cmd.Bool(name)
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseTuneOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    map[string]any
+		wantErr bool
+	}{
+		{"no_options", "\\header { }", map[string]any{}, false},
+		{"single_line", "%% domusic: landscape: true, staff-size: 17, format: book\n", map[string]any{"landscape": true, "staff-size": 17, "format": "book"}, false},
+		{"several_lines", "%% domusic: landscape: true\n\n%% domusic: paper-size: a5\n", map[string]any{"landscape": true, "paper-size": "a5"}, false},
+		{"bad_value", "%% domusic: landscape: yes\n", nil, true},
+		{"after_comments", "% Jig\n\n%% domusic: paper-size: a5\n", map[string]any{"paper-size": "a5"}, false},
+		{"only_at_top", "\\header { }\n%% domusic: paper-size: a5\n", map[string]any{}, false},
+		{"unknown_option", "%% domusic: colour: red\n", nil, true},
+		{"missing_value", "%% domusic: landscape\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTuneOptions(nil, []byte(tt.src))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTuneOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got.values, tt.want) {
+				t.Errorf("parseTuneOptions() = %v, want %v", got.values, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// defaultPostPreset is used for "--post default" unless the configuration
+// has a preset with that name. It only crops the image, which is what the
+// post flag always did.
+var defaultPostPreset = PostPreset{Resolution: 84}
+
+// getPostPreset returns the post preset {name} from the configuration and
+// checks that its settings make sense.
+func getPostPreset(name string) (*PostPreset, error) {
+	presets := GetConfig().Post
+	preset, ok := presets[name]
+	if !ok && name == "default" {
+		preset, ok = defaultPostPreset, true
+	}
+	if !ok {
+		names := slices.Sorted(maps.Keys(presets))
+		return nil, fmt.Errorf("unknown post preset %s, use default or one of: %s", name, strings.Join(names, ", "))
+	}
+
+	if _, _, err := preset.canvasSize(); err != nil {
+		return nil, fmt.Errorf("post preset %s: %w", name, err)
+	}
+	if _, err := parseColor(preset.Background); err != nil {
+		return nil, fmt.Errorf("post preset %s: %w", name, err)
+	}
+
+	return &preset, nil
+}
+
+// canvasSize returns the size of the finished image. A missing width or
+// height is calculated from the other one and the aspect ratio. It is 0 if
+// it can't be, in which case the image gets the size of its content.
+func (p *PostPreset) canvasSize() (int, int, error) {
+	width, height := p.Width, p.Height
+	if p.Aspect == "" {
+		return width, height, nil
+	}
+
+	w, h, ok := strings.Cut(p.Aspect, ":")
+	aw, err1 := strconv.Atoi(strings.TrimSpace(w))
+	ah, err2 := strconv.Atoi(strings.TrimSpace(h))
+	if !ok || err1 != nil || err2 != nil || aw <= 0 || ah <= 0 {
+		return 0, 0, fmt.Errorf("invalid aspect ratio %s, use width:height like 4:5", p.Aspect)
+	}
+	switch {
+	case width > 0 && height == 0:
+		height = width * ah / aw
+	case height > 0 && width == 0:
+		width = height * aw / ah
+	}
+
+	return width, height, nil
+}
+
+// footer returns the footer text for the tune titled {title}.
+func (p *PostPreset) footer(title string) (string, error) {
+	if p.Footer == "" {
+		return "", nil
+	}
+
+	return executeTemplate(p.Footer, map[string]any{"title": title, "url": p.URL})
+}
+
+// parseColor parses a colour given as #rgb or #rrggbb. An empty string is
+// white.
+func parseColor(s string) (color.Color, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if s == "" {
+		return color.White, nil
+	}
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return nil, fmt.Errorf("invalid colour %s, use #rrggbb", s)
+	}
+
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, nil
+}
+
+// postPNG turns the cropped PNG file at {path} into an image for {preset}.
+func postPNG(path string, preset *PostPreset) error {
+	img, err := readPNG(path)
+	if err != nil {
+		return err
+	}
+	width, height, _ := preset.canvasSize()
+	bg, _ := parseColor(preset.Background)
+
+	return writePNG(path, fitImage(img, width, height, preset.Padding, bg))
+}
+
+// fitImage returns {img} centred on a {width} by {height} image with the
+// background colour {bg}, leaving at least {padding} pixels around it. The
+// content is shrunk if it doesn't fit, but never enlarged. A width or height
+// of 0 means the size of the content plus the padding. The content is
+// blended onto the background, so its white becomes the background colour.
+func fitImage(img image.Image, width, height, padding int, bg color.Color) image.Image {
+	size := img.Bounds().Size()
+	scale := 1.0
+	if width > 0 {
+		scale = min(scale, float64(width-2*padding)/float64(size.X))
+	}
+	if height > 0 {
+		scale = min(scale, float64(height-2*padding)/float64(size.Y))
+	}
+	if scale < 1 {
+		img = shrinkImage(img, max(1, int(float64(size.X)*scale)), max(1, int(float64(size.Y)*scale)))
+		size = img.Bounds().Size()
+	}
+	if width == 0 {
+		width = size.X + 2*padding
+	}
+	if height == 0 {
+		height = size.Y + 2*padding
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	br, bgg, bb, _ := bg.RGBA()
+	offset := image.Pt((width-size.X)/2, (height-size.Y)/2)
+	for y := range height {
+		for x := range width {
+			r, g, b := br, bgg, bb
+			p := image.Pt(x, y).Sub(offset).Add(img.Bounds().Min)
+			if p.In(img.Bounds()) {
+				// Multiply blend, with transparent pixels counted as white
+				cr, cg, cb, ca := img.At(p.X, p.Y).RGBA()
+				cr, cg, cb = cr+0xffff-ca, cg+0xffff-ca, cb+0xffff-ca
+				r, g, b = r*cr/0xffff, g*cg/0xffff, b*cb/0xffff
+			}
+			out.Set(x, y, color.RGBA64{uint16(r), uint16(g), uint16(b), 0xffff})
+		}
+	}
+
+	return out
+}
+
+// shrinkImage returns {img} scaled down to {width} by {height}, with each
+// new pixel the average of the pixels it covers.
+func shrinkImage(img image.Image, width, height int) image.Image {
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		y0 := b.Min.Y + y*b.Dy()/height
+		y1 := max(y0+1, b.Min.Y+(y+1)*b.Dy()/height)
+		for x := range width {
+			x0 := b.Min.X + x*b.Dx()/width
+			x1 := max(x0+1, b.Min.X+(x+1)*b.Dx()/width)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			out.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+
+	return out
+}
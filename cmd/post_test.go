@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func Test_PostPreset_canvasSize(t *testing.T) {
+	tests := []struct {
+		name       string
+		preset     PostPreset
+		wantWidth  int
+		wantHeight int
+		wantErr    bool
+	}{
+		{"no_size", PostPreset{}, 0, 0, false},
+		{"width_and_height", PostPreset{Width: 800, Height: 600}, 800, 600, false},
+		{"width_and_aspect", PostPreset{Width: 1080, Aspect: "4:5"}, 1080, 1350, false},
+		{"height_and_aspect", PostPreset{Height: 900, Aspect: "16:9"}, 1600, 900, false},
+		{"bad_aspect", PostPreset{Width: 100, Aspect: "square"}, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, h, err := tt.preset.canvasSize()
+			if w != tt.wantWidth || h != tt.wantHeight || (err != nil) != tt.wantErr {
+				t.Errorf("canvasSize() = %d, %d, %v, want %d, %d", w, h, err, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
+
+func Test_parseColor(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    color.Color
+		wantErr bool
+	}{
+		{"", color.White, false},
+		{"#fdf6e3", color.RGBA{0xfd, 0xf6, 0xe3, 0xff}, false},
+		{"#f00", color.RGBA{0xff, 0, 0, 0xff}, false},
+		{"red", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseColor(tt.value)
+			if got != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("parseColor() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_fitImage(t *testing.T) {
+	bg := color.RGBA{0x10, 0x20, 0x30, 0xff}
+	tests := []struct {
+		name          string
+		width, height int
+		padding       int
+		wantSize      image.Point
+		wantBlack     image.Point
+	}{
+		{"content_size", 0, 0, 5, image.Pt(110, 60), image.Pt(5+20, 5+10)},
+		{"centred", 200, 100, 5, image.Pt(200, 100), image.Pt(50+20, 25+10)},
+		{"shrunk", 60, 60, 5, image.Pt(60, 60), image.Pt(5+10, 17+5)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := testImage(100, 50, image.Rect(20, 10, 40, 30))
+			got := fitImage(img, tt.width, tt.height, tt.padding, bg)
+			if size := got.Bounds().Size(); size != tt.wantSize {
+				t.Fatalf("fitImage() size = %v, want %v", size, tt.wantSize)
+			}
+			if c := color.RGBAModel.Convert(got.At(0, 0)); c != bg {
+				t.Errorf("corner = %v, want background %v", c, bg)
+			}
+			if c := color.GrayModel.Convert(got.At(tt.wantBlack.X, tt.wantBlack.Y)).(color.Gray); c.Y != 0 {
+				t.Errorf("pixel at %v = %v, want black", tt.wantBlack, c)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+// makeReport is the machine readable description of a make run.
+type makeReport struct {
+	Started  time.Time    `json:"started"`
+	Duration float64      `json:"duration"`
+	Built    int          `json:"built"`
+	Skipped  int          `json:"skipped"`
+	Failed   int          `json:"failed"`
+	Tunes    []tuneReport `json:"tunes"`
+}
+
+// tuneReport describes the result for a single tune. Paths are relative to
+// the music root and durations are in seconds.
+type tuneReport struct {
+	Source      string       `json:"source"`
+	Status      string       `json:"status"`
+	Skipped     bool         `json:"skipped"`
+	Outputs     []string     `json:"outputs"`
+	ExitCode    int          `json:"exitCode"`
+	Duration    float64      `json:"duration"`
+	Error       string       `json:"error,omitempty"`
+	Diagnostics []diagnostic `json:"diagnostics"`
+}
+
+// checkReportFormat returns an error if the --report flag has an unknown
+// value.
+func checkReportFormat(cmd *cli.Command) error {
+	if format := cmd.String("report"); format != "" && format != "json" {
+		return printAndReturnError("unknown report format %s", format)
+	}
+	return nil
+}
+
+// progressWriter returns where human readable progress should go. That is
+// stdout, unless stdout is used for the JSON report.
+func progressWriter(cmd *cli.Command) io.Writer {
+	if cmd.String("report") == "json" && cmd.String("report-file") == "" {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// writeReport writes the JSON report for {results} to the file given with
+// --report-file, or to stdout if --report json is given. It does nothing if
+// neither flag is set.
+func writeReport(cmd *cli.Command, results []makeResult, started time.Time) error {
+	reportFile := cmd.String("report-file")
+	if reportFile == "" && cmd.String("report") != "json" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(newMakeReport(results, started), "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if reportFile == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(reportFile, data, 0644)
+}
+
+func newMakeReport(results []makeResult, started time.Time) makeReport {
+	report := makeReport{
+		Started:  started,
+		Duration: time.Since(started).Seconds(),
+		Tunes:    []tuneReport{},
+	}
+	for _, r := range results {
+		tune := tuneReport{
+			Source:      makeRel(r.source),
+			Skipped:     r.skipped,
+			Outputs:     []string{},
+			ExitCode:    r.exitCode,
+			Duration:    r.duration.Seconds(),
+			Diagnostics: r.diagnostics,
+		}
+		for _, o := range r.outputs {
+			tune.Outputs = append(tune.Outputs, makeRel(o))
+		}
+		if tune.Diagnostics == nil {
+			tune.Diagnostics = []diagnostic{}
+		}
+
+		switch {
+		case r.err != nil:
+			tune.Status = "failed"
+			tune.Error = r.err.Error()
+			report.Failed++
+		case r.skipped:
+			tune.Status = "skipped"
+			report.Skipped++
+		default:
+			tune.Status = "built"
+			report.Built++
+		}
+		report.Tunes = append(report.Tunes, tune)
+	}
+
+	return report
+}
@@ -28,11 +28,16 @@ func Execute() {
 		},
 		Commands: []*cli.Command{
 			collectionCmd,
+			depsCmd,
 			editCmd,
+			listCmd,
 			makeCmd,
+			rdepsCmd,
 			syncCmd,
+			upgradeCmd,
 			versionCmd,
 			viewCmd,
+			watchCmd,
 		},
 	}
 
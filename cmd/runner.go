@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// lilypondRunner runs the Lilypond executable. The make pipeline only talks
+// to Lilypond through this interface so it can be replaced in tests.
+type lilypondRunner interface {
+	// run runs Lilypond with {args} in the directory {dir} and returns the
+	// combined stdout and stderr.
+	run(ctx context.Context, dir string, args []string) ([]byte, error)
+	// version returns the first line of Lilypond's version output, which
+	// is empty if Lilypond didn't print anything.
+	version(ctx context.Context) (string, error)
+	// convert runs convert-ly on {file} and returns the converted source.
+	// {to} is the version to convert to, or empty for the version of
+	// convert-ly itself.
+	convert(ctx context.Context, file, to string) ([]byte, error)
+}
+
+// newLilypondRunner returns the runner used for all Lilypond invocations.
+// Tests replace it with a fake.
+var newLilypondRunner = func() lilypondRunner {
+	return newExecRunner(GetConfig().Lilypond)
+}
+
+// execRunner runs a real Lilypond binary.
+type execRunner struct {
+	binary    string
+	convertLy string
+	args      []string
+	timeout   time.Duration
+}
+
+// newExecRunner creates a runner from the configuration. An empty binary
+// means "lilypond" from the path, and an empty or broken timeout means no
+// timeout at all. Unless configured, convert-ly is expected next to the
+// Lilypond binary.
+func newExecRunner(cfg LilypondConfig) *execRunner {
+	r := &execRunner{binary: cfg.Binary, convertLy: cfg.ConvertLy, args: cfg.Args}
+	if r.binary == "" {
+		r.binary = "lilypond"
+	}
+	if r.convertLy == "" {
+		r.convertLy = "convert-ly"
+		if filepath.IsAbs(r.binary) {
+			r.convertLy = filepath.Join(filepath.Dir(r.binary), "convert-ly")
+		}
+	}
+	if cfg.Timeout != "" {
+		timeout, err := time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			printWarning("ignoring invalid lilypond timeout %s: %w", cfg.Timeout, err)
+		}
+		r.timeout = timeout
+	}
+
+	return r
+}
+
+func (r *execRunner) run(ctx context.Context, dir string, args []string) ([]byte, error) {
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	c := exec.CommandContext(ctx, r.binary, append(append([]string{}, r.args...), args...)...)
+	c.Dir = dir
+	out, err := c.CombinedOutput()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return out, fmt.Errorf("%s timed out after %s", r.binary, r.timeout)
+	}
+
+	return out, err
+}
+
+func (r *execRunner) version(ctx context.Context) (string, error) {
+	out, err := exec.CommandContext(ctx, r.binary, "--version").Output()
+	if err != nil {
+		return "", err
+	}
+	if len(out) == 0 {
+		return "", nil
+	}
+
+	return string(bytes.Split(out, []byte("\n"))[0]), nil
+}
+
+func (r *execRunner) convert(ctx context.Context, file, to string) ([]byte, error) {
+	args := []string{}
+	if to != "" {
+		args = append(args, "--to="+to)
+	}
+	var stderr bytes.Buffer
+	c := exec.CommandContext(ctx, r.convertLy, append(args, file)...)
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w\n%s", r.convertLy, err, stderr.Bytes())
+	}
+
+	return out, nil
+}
+
+// exitCode returns the exit status carried by {err}: 0 for no error, the
+// status of the process if it ran and failed, and -1 if it couldn't run.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+
+	return -1
+}
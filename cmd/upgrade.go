@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+var upgradeCmd = &cli.Command{
+	Name:  "upgrade",
+	Usage: "Update the Lilypond syntax of music file(s) with convert-ly",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "dry-run",
+			Aliases: []string{"n"},
+			Usage:   "show the changes as a diff without changing any files",
+		},
+		&cli.BoolFlag{
+			Name:  "no-backup",
+			Usage: "don't save the original file as {file}.bak",
+		},
+		&cli.StringFlag{
+			Name:  "to",
+			Usage: "convert to Lilypond {version} instead of the installed one",
+		},
+	},
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		files, err := expandGlobs(cmd.Args().Slice())
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return printAndReturnError("upgrade needs at least one file")
+		}
+
+		runner := newLilypondRunner()
+		failed := 0
+		for _, f := range files {
+			src := getSourcePath(f)
+			fmt.Println("Upgrading file", src)
+			if err := upgradeTune(ctx, runner, cmd, src, os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				failed++
+			}
+		}
+		if failed > 0 {
+			return printAndReturnError("%d of %d tunes failed", failed, len(files))
+		}
+		return nil
+	},
+}
+
+// upgradeTune runs convert-ly on {src} and replaces the file with the
+// result, keeping a backup unless the flags in {cmd} say otherwise. For a
+// dry run the changes are written to {w} as a diff instead.
+func upgradeTune(ctx context.Context, runner lilypondRunner, cmd *cli.Command, src string, w io.Writer) error {
+	original, err := os.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("failed to read source file %s: %w", src, err)
+	}
+	converted, err := runner.convert(ctx, src, cmd.String("to"))
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case bytes.Equal(original, converted):
+		fmt.Fprintln(w, "  * Already up to date")
+	case cmd.Bool("dry-run"):
+		fmt.Fprint(w, unifiedDiff(makeRel(src), original, converted, 3))
+	default:
+		if !cmd.Bool("no-backup") {
+			if _, err := copyFile(src, src+".bak"); err != nil {
+				return fmt.Errorf("failed to back up %s: %w", src, err)
+			}
+			fmt.Fprintln(w, "  * Saved original as", makeRel(src)+".bak")
+		}
+		if err := os.WriteFile(src, converted, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", src, err)
+		}
+		fmt.Fprintln(w, "  * Upgraded")
+	}
+
+	return nil
+}
+
+// diffOp is a single line of a diff: kept (' '), removed ('-') or added
+// ('+'). {a} and {b} are the indexes of the line in the old and new text.
+type diffOp struct {
+	kind byte
+	line string
+	a, b int
+}
+
+// unifiedDiff returns the differences between {a} and {b} in unified diff
+// format, with {context} lines of context around each change and both
+// sides labelled {name}. It is empty if there are no differences.
+func unifiedDiff(name string, a, b []byte, context int) string {
+	ops := diffLines(splitLines(a), splitLines(b))
+	changes := []int{}
+	for i, op := range ops {
+		if op.kind != ' ' {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", name, name)
+	for first := 0; first < len(changes); {
+		// Changes closer than twice the context go in the same hunk
+		last := first
+		for last+1 < len(changes) && changes[last+1]-changes[last] <= 2*context {
+			last++
+		}
+		from := max(0, changes[first]-context)
+		to := min(len(ops), changes[last]+context+1)
+
+		aLen, bLen := 0, 0
+		for _, op := range ops[from:to] {
+			if op.kind != '+' {
+				aLen++
+			}
+			if op.kind != '-' {
+				bLen++
+			}
+		}
+		aStart, bStart := ops[from].a, ops[from].b
+		if aLen > 0 {
+			aStart++
+		}
+		if bLen > 0 {
+			bStart++
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", aStart, aLen, bStart, bLen)
+		for _, op := range ops[from:to] {
+			fmt.Fprintf(&sb, "%c%s\n", op.kind, op.line)
+		}
+		first = last + 1
+	}
+
+	return sb.String()
+}
+
+// splitLines splits {data} into lines, ignoring a final newline.
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// diffLines returns the shortest list of operations that turns the lines
+// {a} into {b}, based on their longest common subsequence.
+func diffLines(a, b []string) []diffOp {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	ops := []diffOp{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i], i, j})
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', a[i], i, j})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j], i, j})
+			j++
+		}
+	}
+
+	return ops
+}
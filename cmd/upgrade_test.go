@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v3"
+)
+
+func Test_unifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", ""},
+		{"changed_line", "a\nb\nc\n", "a\nB\nc\n", "--- t.ly\n+++ t.ly\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"},
+		{"added_line", "a\n", "a\nb\n", "--- t.ly\n+++ t.ly\n@@ -1,1 +1,2 @@\n a\n+b\n"},
+		{"two_hunks", "1\n2\n3\n4\n5\n6\n7\n8\n", "0\n2\n3\n4\n5\n6\n7\n9\n", "--- t.ly\n+++ t.ly\n@@ -1,2 +1,2 @@\n-1\n+0\n 2\n@@ -7,2 +7,2 @@\n 7\n-8\n+9\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("t.ly", []byte(tt.a), []byte(tt.b), 1); got != tt.want {
+				t.Errorf("unifiedDiff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_upgradeTune(t *testing.T) {
+	root, fake := setupMakeTest(t, map[string]string{"tune.ly": "\\version \"2.22.1\"\n" + testTune})
+	src := filepath.Join(root, "tune.ly")
+	upgrade := func(args ...string) string {
+		var out bytes.Buffer
+		cmd := &cli.Command{Flags: upgradeCmd.Flags, Action: func(ctx context.Context, cmd *cli.Command) error {
+			return upgradeTune(ctx, fake, cmd, src, &out)
+		}}
+		if err := cmd.Run(context.Background(), append([]string{"upgrade"}, args...)); err != nil {
+			t.Fatalf("upgrade failed: %v", err)
+		}
+		return out.String()
+	}
+
+	out := upgrade("--dry-run")
+	if !strings.Contains(out, "-\\version \"2.22.1\"\n+\\version \"2.24.3\"\n") {
+		t.Errorf("dry run should print a diff, got:\n%s", out)
+	}
+	if data, _ := os.ReadFile(src); !strings.Contains(string(data), "2.22.1") {
+		t.Errorf("dry run should not change the file")
+	}
+
+	upgrade()
+	if data, _ := os.ReadFile(src); !strings.Contains(string(data), "2.24.3") {
+		t.Errorf("the file should be upgraded")
+	}
+	if data, err := os.ReadFile(src + ".bak"); err != nil || !strings.Contains(string(data), "2.22.1") {
+		t.Errorf("the original should be kept as a backup: %v", err)
+	}
+
+	if out := upgrade(); !strings.Contains(out, "Already up to date") {
+		t.Errorf("upgrading again should do nothing, got:\n%s", out)
+	}
+}
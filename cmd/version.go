@@ -1,10 +1,8 @@
 package cmd
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"os/exec"
 	"runtime"
 
 	"github.com/urfave/cli/v3"
@@ -25,21 +23,20 @@ var versionCmd = &cli.Command{
 	Usage: "Show version of this program and of Lilypond",
 	Action: func(ctx context.Context, cmd *cli.Command) error {
 		fmt.Printf("domusic v%s (%s) built %s %s/%s\n", version, gitSha1, buildTime, runtime.GOOS, runtime.GOARCH)
-		fmt.Println(lilyVersion())
+		fmt.Println(lilyVersion(ctx))
 		fmt.Println("Version cmd:", lowestLilyVersion)
 		fmt.Println("Config path:", configPath)
 		return nil
 	},
 }
 
-func lilyVersion() string {
-	c := exec.Command("lilypond", "--version")
-	out, err := c.Output()
+func lilyVersion(ctx context.Context) string {
+	v, err := newLilypondRunner().version(ctx)
 	if err != nil {
 		return "(lilypond not found or failed to run)"
 	}
-	if len(out) == 0 {
+	if v == "" {
 		return "(no version output)"
 	}
-	return string(bytes.Split(out, []byte("\n"))[0])
+	return v
 }
@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+var watchCmd = &cli.Command{
+	Name:  "watch",
+	Usage: "Watch music file(s) and run Lilypond on the ones that change",
+	Flags: append(makeFlags(),
+		&cli.DurationFlag{
+			Name:  "interval",
+			Value: time.Second,
+			Usage: "how often to look for changed files",
+		},
+		&cli.DurationFlag{
+			Name:  "debounce",
+			Value: 500 * time.Millisecond,
+			Usage: "how long files must stay unchanged before building",
+		},
+	),
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		config := GetConfig()
+		config.FontInclude = cmd.String("font-include")
+		if err := checkReportFormat(cmd); err != nil {
+			return err
+		}
+
+		w := &watcher{cmd: cmd, cache: loadBuildCache()}
+		return w.run(ctx)
+	},
+}
+
+type watcher struct {
+	cmd   *cli.Command
+	cache *buildCache
+}
+
+// fileStamp is what the watcher compares to decide if a file has changed.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// scanSources returns the stamps of all Lilypond files in the music
+// hierarchy, keyed on their absolute paths.
+func scanSources() (map[string]fileStamp, error) {
+	files, err := findSources(".ly", ".ily")
+	if err != nil {
+		return nil, err
+	}
+
+	stamps := map[string]fileStamp{}
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			// The file may be gone already, e.g. an editor backup
+			continue
+		}
+		if abs, err := filepath.Abs(f); err == nil {
+			f = abs
+		}
+		stamps[f] = fileStamp{info.ModTime(), info.Size()}
+	}
+
+	return stamps, nil
+}
+
+// run polls the music hierarchy until {ctx} is cancelled. Changed files are
+// collected until nothing has changed for the debounce period, and then
+// the affected tunes are built in one go. That way an editor writing a
+// file in several steps only triggers one build.
+func (w *watcher) run(ctx context.Context) error {
+	stamps, err := scanSources()
+	if err != nil {
+		return printAndReturnError("failed to scan music files: %w", err)
+	}
+	fmt.Println("Watching", pathFromRoot(), "for changes")
+
+	debounce := w.cmd.Duration("debounce")
+	ticker := time.NewTicker(w.cmd.Duration("interval"))
+	defer ticker.Stop()
+
+	changed := map[string]bool{}
+	var lastChange time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		current, err := scanSources()
+		if err != nil {
+			printWarning("failed to scan music files: %w", err)
+			continue
+		}
+		for f, stamp := range current {
+			old, ok := stamps[f]
+			if !ok || !old.modTime.Equal(stamp.modTime) || old.size != stamp.size {
+				changed[f] = true
+				lastChange = time.Now()
+			}
+		}
+		stamps = current
+
+		if len(changed) > 0 && time.Since(lastChange) >= debounce {
+			w.build(ctx, changed)
+			changed = map[string]bool{}
+		}
+	}
+}
+
+// build runs make on all watched tunes that are in {changed} or include a
+// file in {changed}.
+func (w *watcher) build(ctx context.Context, changed map[string]bool) {
+	tunes, err := w.watchedTunes()
+	if err != nil {
+		printWarning("failed to find tunes to watch: %w", err)
+		return
+	}
+
+	targets := []string{}
+	for _, tune := range slices.Sorted(maps.Keys(tunes)) {
+		if changed[tune] || slices.ContainsFunc(tunes[tune], func(dep string) bool { return changed[dep] }) {
+			targets = append(targets, tune)
+		}
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	started := time.Now()
+	results := buildTunes(ctx, w.cmd, targets, w.cache)
+	if err := w.cache.save(); err != nil {
+		printWarning("failed to save build cache: %w", err)
+	}
+	if err := writeReport(w.cmd, results, started); err != nil {
+		printWarning("failed to write report: %w", err)
+	}
+	printSummary(progressWriter(w.cmd), results, len(targets))
+}
+
+// watchedTunes returns the absolute paths of the tunes given on the command
+// line, or of all tunes in the music hierarchy if none are given, with the
+// full paths of the files that each of them includes. Glob patterns are
+// expanded each time so new files are picked up. Files in the hierarchy
+// that other files include are not tunes and are left out, like shared
+// headers.
+func (w *watcher) watchedTunes() (map[string][]string, error) {
+	var files []string
+	var err error
+	if w.cmd.Args().Len() == 0 {
+		files, err = findTunes()
+	} else {
+		files, err = expandGlobs(w.cmd.Args().Slice())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// A tune whose includes can't be found is only built when it changes
+	m := &maker{cmd: w.cmd, out: os.Stdout}
+	tunes := map[string][]string{}
+	included := map[string]bool{}
+	for _, f := range files {
+		f = getSourcePath(f)
+		if abs, err := filepath.Abs(f); err == nil {
+			f = abs
+		}
+		deps, _ := m.dependencies(f)
+		tunes[f] = deps
+		for _, dep := range deps {
+			included[dep] = true
+		}
+	}
+	if w.cmd.Args().Len() == 0 {
+		for f := range tunes {
+			if included[f] {
+				delete(tunes, f)
+			}
+		}
+	}
+
+	return tunes, nil
+}
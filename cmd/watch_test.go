@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"context"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v3"
+)
+
+// runWatcher calls {fn} with a watcher for the watch command given {args}.
+func runWatcher(args []string, fn func(ctx context.Context, w *watcher) error) error {
+	cmd := &cli.Command{
+		Name:  "watch",
+		Flags: makeFlags(),
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			return fn(ctx, &watcher{cmd: cmd, cache: loadBuildCache()})
+		},
+	}
+	return cmd.Run(context.Background(), append([]string{"watch", "--jobs", "1"}, args...))
+}
+
+// builtTunes returns the sorted paths, relative to the music root, of the
+// tunes that {fake} was asked to build.
+func builtTunes(fake *fakeRunner) []string {
+	tunes := []string{}
+	for _, tp := range fake.templates {
+		first, _, _ := strings.Cut(tp, "\n")
+		src := strings.TrimSuffix(strings.TrimPrefix(first, "%% Generated from "), " by domusic")
+		tunes = append(tunes, makeRel(src))
+	}
+	slices.Sort(tunes)
+
+	return slices.Compact(tunes)
+}
+
+func Test_watcher_build(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		changed []string
+		want    []string
+	}{
+		{"tune_changed", nil, []string{"jigs/tune.ly"}, []string{"jigs/tune.ly"}},
+		{"shared_include", nil, []string{"defs/common.ily"}, []string{"jigs/slip.ly", "jigs/tune.ly", "reels/reel.ly"}},
+		{"local_include", nil, []string{"reels/local.ily"}, []string{"reels/reel.ly"}},
+		{"ly_include", nil, []string{"header_default.ly"}, []string{"jigs/slip.ly"}},
+		{"unused_include", nil, []string{"fonts/myfont.ily"}, []string{}},
+		{"font_include", []string{"--font-include", "fonts/myfont"}, []string{"fonts/myfont.ily"}, []string{"jigs/slip.ly", "jigs/tune.ly", "reels/other.ly", "reels/reel.ly"}},
+		{"only_watched", []string{"jigs/*"}, []string{"defs/common.ily"}, []string{"jigs/slip.ly", "jigs/tune.ly"}},
+		{"not_watched", []string{"jigs/tune"}, []string{"reels/reel.ly"}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, fake := setupMakeTest(t, dependencyTestFiles)
+			changed := map[string]bool{}
+			for _, c := range tt.changed {
+				changed[pathFromRoot(c)] = true
+			}
+
+			err := runWatcher(tt.args, func(ctx context.Context, w *watcher) error {
+				GetConfig().FontInclude = w.cmd.String("font-include")
+				w.build(ctx, changed)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := builtTunes(fake); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("built %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_watcher_watchedTunes(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"all_tunes", nil, []string{"jigs/slip.ly", "jigs/tune.ly", "reels/other.ly", "reels/reel.ly"}},
+		{"glob", []string{"reels/*.ly"}, []string{"reels/other.ly", "reels/reel.ly"}},
+		{"names", []string{"reels/reel", "jigs/tune", "reels/reel.ly"}, []string{"jigs/tune.ly", "reels/reel.ly"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupMakeTest(t, dependencyTestFiles)
+
+			var tunes map[string][]string
+			err := runWatcher(tt.args, func(ctx context.Context, w *watcher) error {
+				var err error
+				tunes, err = w.watchedTunes()
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, tune := range slices.Sorted(maps.Keys(tunes)) {
+				got = append(got, makeRel(tune))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("watchedTunes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}